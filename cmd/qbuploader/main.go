@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"qbuploader/internal/config"
 	"qbuploader/internal/database"
@@ -18,13 +22,21 @@ func main() {
 		os.Exit(1)
 	}
 	logger.Init()
-	if err := database.Init(); err != nil {
-		logger.Log.Errorf("数据库初始化失败！程序无法启动。")
-		logger.Log.Errorf("原因: %v", err)
-		os.Exit(1)
-	}
-	defer database.DB.Close()
 	log := logger.Log
+	defer closeDatabase()
+
+	// 收到 Ctrl+C 或服务停止信号时取消 ctx：正在运行的外部命令会被结束，中断的上传下次运行时继续。
+	// 之后恢复默认的信号处理，再收到一次信号就直接退出。
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Warnf("-> 收到退出信号 (%v)，正在停止进行中的任务... (再次按下 Ctrl+C 将强制退出)", sig)
+		cancel()
+	}()
 	app := &cli.App{
 		Name:    "qbuploader",
 		Usage:   "qBittorrent 自动化保种与备份工具",
@@ -41,19 +53,110 @@ func main() {
 					contentPath := c.Args().Get(0)
 					torrentName := c.Args().Get(1)
 					infoHash := c.Args().Get(2)
-					return scheduler.RunUploadMode(infoHash, torrentName, contentPath)
+					return scheduler.RunUploadMode(c.Context, infoHash, torrentName, contentPath)
 				},
 			},
 			{
 				Name:  "cleanup",
 				Usage: "执行定期巡检和清理 (由任务计划程序调用)",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "只输出清理计划 (将要校验的任务、删除的路径和 qB 操作)，不修改任何内容",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "清理计划的输出格式: table 或 json (配合 --dry-run 使用)",
+					},
+				},
+				Before: func(c *cli.Context) error {
+					if !c.Bool("dry-run") {
+						return openDatabase(false)
+					}
+					// 日志改为输出到标准错误，标准输出只保留清理计划，便于重定向或交给其他程序处理
+					log.SetOutput(os.Stderr)
+					return openDatabase(true)
+				},
 				Action: func(c *cli.Context) error {
-					return scheduler.RunCleanupMode()
+					if c.Bool("dry-run") {
+						return scheduler.RunCleanupPlan(c.Context, os.Stdout, c.String("format"))
+					}
+					return scheduler.RunCleanupMode(c.Context)
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "轮询 qBittorrent，补充登记并上传已完成但未处理的任务",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "once",
+						Usage: "只扫描一次就退出 (适合由任务计划程序调用)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return scheduler.RunWatchMode(c.Context, c.Bool("once"))
+				},
+			},
+			{
+				Name:      "restore-local",
+				Usage:     "将回收站中的任务内容移回原位置，并让 qB 重新校验",
+				ArgsUsage: "<info_hash>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						return fmt.Errorf("restore-local 命令需要 1 个参数: info_hash")
+					}
+					return scheduler.RunRestoreLocal(c.Args().Get(0))
+				},
+			},
+			{
+				Name:  "daemon",
+				Usage: "以守护进程方式常驻运行，按固定并发数处理上传队列并定时巡检清理",
+				Action: func(c *cli.Context) error {
+					return scheduler.RunDaemonMode(c.Context)
 				},
 			},
 		},
 	}
-	if err := app.Run(os.Args); err != nil {
+	for _, cmd := range app.Commands {
+		if cmd.Before == nil {
+			cmd.Before = func(*cli.Context) error { return openDatabase(false) }
+		}
+	}
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Warnf("程序已中断: %v", err)
+			closeDatabase()
+			os.Exit(130)
+		}
 		log.Fatalf("程序执行出错: %v", err)
 	}
 }
+
+// openDatabase 在执行命令前打开数据库，并恢复上次异常退出时遗留的任务。
+// readOnly 为 true 时 (cleanup --dry-run) 以只读方式打开，不升级数据库，也不恢复遗留的任务。
+func openDatabase(readOnly bool) error {
+	log := logger.Log
+	initDB := database.Init
+	if readOnly {
+		initDB = database.InitReadOnly
+	}
+	if err := initDB(); err != nil {
+		log.Errorf("数据库初始化失败！程序无法启动。")
+		log.Errorf("原因: %v", err)
+		os.Exit(1)
+	}
+	if readOnly {
+		return nil
+	}
+	if err := scheduler.RecoverStaleTasks(); err != nil {
+		log.Warnf("启动检查失败: %v", err)
+	}
+	return nil
+}
+
+func closeDatabase() {
+	if database.DB != nil {
+		database.DB.Close()
+	}
+}
@@ -1,87 +1,227 @@
 package baidupcs
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"qbuploader/internal/config"
 	"qbuploader/internal/logger"
+	"qbuploader/internal/proc"
+	"qbuploader/internal/remotepath"
+	"qbuploader/internal/storage"
 )
 
-// Uploader 封装了所有与 BaiduPCS-Go 相关的操作。
+// Uploader 封装了所有与 BaiduPCS-Go 相关的操作，实现了 storage.Backend 接口。
 type Uploader struct {
 	executablePath string
 	extraArgs      []string
+	verifyMD5      bool
 }
 
+var (
+	_ storage.Backend     = (*Uploader)(nil)
+	_ storage.NameChecker = (*Uploader)(nil)
+)
+
 // NewUploader 创建一个新的 Uploader 实例。
 func NewUploader() *Uploader {
 	return &Uploader{
 		executablePath: config.Cfg.Uploader.Path,
 		extraArgs:      config.Cfg.Uploader.ExtraArgs,
+		verifyMD5:      config.Cfg.Uploader.VerifyMD5,
 	}
 }
 
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "baidupcs"
+}
+
+// CheckName 检查名称能否在百度网盘中使用。
+func (u *Uploader) CheckName(name string) error {
+	return remotepath.CheckName(name)
+}
+
 // Upload 执行上传操作。
-func (u *Uploader) Upload(localPath, remoteDir, torrentName string) error {
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
 	log := logger.Log
-	remotePath := fmt.Sprintf("%s/%s", remoteDir, torrentName)
 
 	args := []string{
 		"upload",
 		localPath,
-		remotePath,
+		remoteDir,
 	}
 	args = append(args, u.extraArgs...)
 
-	log.Infof("  -> 正在上传: %s -> %s", localPath, remotePath)
-	log.Debugf("  -> 执行命令: %s %v", u.executablePath, args)
+	log.Infof("  -> 正在上传: %s -> %s", localPath, remoteDir)
 
-	// 使用带有超时的上下文，防止命令卡死
-	ctx, cancel := context.WithTimeout(context.Background(), 24*time.Hour) // 24小时超时
-	defer cancel()
+	// 使用带有超时的上下文，防止命令卡死；程序退出时 ctx 被取消，命令会被立即结束
+	stdout, stderr, err := u.run(ctx, 24*time.Hour, args...) // 24小时超时
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("上传被中断: %w", ctx.Err())
+	}
+	if err != nil {
+		log.Errorf("BaiduPCS-Go 上传失败。输出: %s, 错误: %s", stdout, stderr)
+		return fmt.Errorf("执行 BaiduPCS-Go 上传命令失败: %w", err)
+	}
+
+	log.Debugf("BaiduPCS-Go 上传成功。输出: %s", stdout)
+	return nil
+}
 
-	cmd := exec.CommandContext(ctx, u.executablePath, args...)
+// Exists 校验网盘文件是否存在。
+// 只有 BaiduPCS-Go 明确报告“不存在”时才返回 false；网络错误等无法确认的情况返回 error。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验网盘文件: %s", remotePath)
+	_, err := u.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// md5Pattern 匹配 `meta` 输出中的 md5 值。
+var md5Pattern = regexp.MustCompile(`\b[0-9a-fA-F]{32}\b`)
 
-	if err := cmd.Run(); err != nil {
-		log.Errorf("BaiduPCS-Go 上传失败。输出: %s, 错误: %s", stdout.String(), stderr.String())
-		return fmt.Errorf("执行 BaiduPCS-Go 上传命令失败: %w", err)
+// Stat 通过 `meta` 命令获取网盘文件信息。
+// 启用 [Uploader] Verify_MD5 时同时返回网盘记录的 md5。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	stdout, stderr, err := u.run(ctx, 5*time.Minute, "meta", remotePath)
+	if isNotFoundOutput(stdout + stderr) {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("执行 BaiduPCS-Go meta 命令失败: %w (%s)", err, strings.TrimSpace(stderr))
 	}
 
-	log.Debugf("BaiduPCS-Go 上传成功。输出: %s", stdout.String())
+	info := &storage.FileInfo{Path: remotePath, Name: path.Base(remotePath), Size: -1}
+	parsed := false
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		key, value, ok := splitMetaLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch key {
+		case "类型":
+			info.IsDir = value == "目录"
+			parsed = true
+		case "文件大小":
+			// 形如 "10254, 10.01KB"，逗号前为精确字节数
+			sizeText, _, _ := strings.Cut(value, ",")
+			if size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 10, 64); err == nil {
+				info.Size = size
+			}
+		case "md5":
+			// 形如 "md5 (可能不正确)  d41d8cd98f00b204e9800998ecf8427e"
+			if u.verifyMD5 {
+				info.MD5 = strings.ToLower(md5Pattern.FindString(value))
+			}
+		case "修改日期":
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+				info.ModTime = t
+			}
+		}
+	}
+	if !parsed || (!info.IsDir && info.Size < 0) {
+		return nil, fmt.Errorf("无法解析 BaiduPCS-Go meta 命令的输出: %s", strings.TrimSpace(stdout+stderr))
+	}
+	return info, nil
+}
+
+// Delete 删除网盘上的文件或目录。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	stdout, stderr, err := u.run(ctx, 5*time.Minute, "rm", remotePath)
+	if err != nil {
+		return fmt.Errorf("执行 BaiduPCS-Go rm 命令失败: %w (%s)", err, strings.TrimSpace(stdout+stderr))
+	}
 	return nil
 }
 
-// CheckFileExists 校验网盘文件是否存在。
-func (u *Uploader) CheckFileExists(remoteDir, torrentName string) (bool, error) {
-	log := logger.Log
-	remotePath := fmt.Sprintf("%s/%s", remoteDir, torrentName)
+// lsRowPattern 匹配 `ls` 输出中的文件行: 序号、大小、修改日期、文件名（目录以 / 结尾）。
+var lsRowPattern = regexp.MustCompile(`^\s*\d+\s+(\S+)\s+(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\s+(.+?)\s*$`)
 
-	args := []string{
-		"ls",
-		remotePath,
+// List 列出网盘目录下的直接子项。
+// `ls` 输出的文件大小是经过换算的可读格式，因此这里的 Size 一律为 -1，需要精确大小时请使用 Stat。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	stdout, stderr, err := u.run(ctx, 5*time.Minute, "ls", remoteDir)
+	if isNotFoundOutput(stdout + stderr) {
+		return nil, fmt.Errorf("%s: %w", remoteDir, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("执行 BaiduPCS-Go ls 命令失败: %w (%s)", err, strings.TrimSpace(stderr))
 	}
 
-	log.Infof("  -> 正在校验网盘文件: %s", remotePath)
-	log.Debugf("  -> 执行命令: %s %v", u.executablePath, args)
+	var entries []storage.FileInfo
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		m := lsRowPattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		name := m[3]
+		isDir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		entry := storage.FileInfo{
+			Path:  storage.JoinRemote(remoteDir, name),
+			Name:  name,
+			Size:  -1,
+			IsDir: isDir,
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", m[2], time.Local); err == nil {
+			entry.ModTime = t
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// run 以给定的超时时间执行一条 BaiduPCS-Go 命令，返回标准输出和标准错误。
+func (u *Uploader) run(ctx context.Context, timeout time.Duration, args ...string) (string, string, error) {
+	logger.Log.Debugf("  -> 执行命令: %s %v", u.executablePath, args)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // 5分钟超时
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, u.executablePath, args...)
+	cmd := proc.CommandContext(ctx, u.executablePath, args...)
 
-	// 对于 `ls`，我们只关心它是否成功执行（返回码为0）。
-	// 如果文件不存在，它会返回非0，并把错误信息打印到 stderr。
-	if err := cmd.Run(); err != nil {
-		return false, nil // 认为文件不存在，这不是一个程序错误。
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// splitMetaLine 将 `meta` 输出的一行拆分为键和值。
+func splitMetaLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", false
 	}
+	key := fields[0]
+	value := strings.TrimSpace(strings.TrimPrefix(line, key))
+	return key, value, true
+}
 
-	return true, nil
-}
\ No newline at end of file
+// notFoundPattern 匹配 BaiduPCS-Go 报告“文件或目录不存在”的错误行，例如
+// "远端服务器返回错误, 代码: 31066, 消息: 文件或目录不存在"。
+// 只匹配错误代码和错误消息，不能匹配输出中的文件名，否则名称中含有“不存在”的文件会被当作缺失。
+var notFoundPattern = regexp.MustCompile(`(?m)(?:代码|errno)\s*[:：=]\s*31066\b|(?:^\s*|消息\s*[:：]\s*)文件或目录不存在`)
+
+// isNotFoundOutput 判断 BaiduPCS-Go 的输出是否表示“文件或目录不存在”。
+func isNotFoundOutput(output string) bool {
+	return notFoundPattern.MatchString(output)
+}
@@ -0,0 +1,23 @@
+package baidupcs
+
+import "testing"
+
+func TestIsNotFoundOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"[0] 获取文件/目录的元信息出错, 遇到错误, 远端服务器返回错误, 代码: 31066, 消息: 文件或目录不存在\n", true},
+		{"获取目录下的文件列表出错, 错误代码: 31066\n", true},
+		{"文件或目录不存在\n", true},
+		// 文件名中含有“不存在”或 31066 时不能当作缺失
+		{"文件路径          /tv/不存在的房间/ep1.mkv\n文件大小          1024, 1.00KB\n", false},
+		{"  0   1.00KB  2024-05-01 12:00:00  31066.mkv\n", false},
+		{"[0] 获取文件/目录的元信息出错, 网络错误: dial tcp: i/o timeout\n", false},
+	}
+	for _, tt := range tests {
+		if got := isNotFoundOutput(tt.output); got != tt.want {
+			t.Errorf("isNotFoundOutput(%q) = %v，应为 %v", tt.output, got, tt.want)
+		}
+	}
+}
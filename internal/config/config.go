@@ -4,7 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"qbuploader/internal/policy"
+	"qbuploader/internal/remotepath"
 
 	"gopkg.in/ini.v1"
 )
@@ -13,9 +19,48 @@ var Cfg *Config
 
 type Config struct {
 	Uploader struct {
+		Backends  []string // 启用的存储后端，按配置顺序排列
+		Quorum    int      // 至少需要多少个后端成功，任务才算完成
 		Path      string
 		RemoteDir string
-		ExtraArgs []string
+		// RemotePath 是上传目录模板，相对于 RemoteDir，详见 remotepath 包
+		RemotePath string
+		ExtraArgs  []string
+		VerifyMD5  bool // 清理前是否比对百度网盘记录的 md5
+	}
+	Rclone struct {
+		Path       string
+		Remote     string
+		ExtraArgs  []string
+		VerifyHash bool
+	}
+	Local struct {
+		Root string
+	}
+	S3 struct {
+		Endpoint   string
+		Region     string
+		Bucket     string
+		AccessKey  string
+		SecretKey  string
+		UseSSL     bool
+		PathStyle  bool
+		PartSizeMB int
+	}
+	WebDAV struct {
+		URL      string
+		Username string
+		Password string
+	}
+	SFTP struct {
+		Host                  string
+		Username              string
+		Password              string
+		PrivateKey            string
+		PrivateKeyPassphrase  string
+		KnownHosts            string
+		InsecureIgnoreHostKey bool
+		Root                  string
 	}
 	QBittorrent struct {
 		Host     string
@@ -23,15 +68,49 @@ type Config struct {
 		Password string
 	}
 	SeedingPolicy struct {
-		// TargetRatio 和 TargetSeedingHours 不再使用，但暂时保留以防未来需要
+		// TargetRatio、TargetSeedingHours 和 Cleanup_Target_States 仅在未设置 Cleanup_Condition 时用于生成默认条件
 		TargetRatio           float64
 		TargetSeedingHours    int
-		ActionAfterProcess    string
-		Cleanup_Target_States string // <<<--- 【新增】在最终使用的 Config 结构体中添加
+		ActionAfterProcess    string            // 已校验并转为小写，取值见 ValidateAction
+		ActionCategory        string            // move_to_category 使用的分类
+		ActionTag             string            // set_tag 使用的标签
+		Cleanup_Target_States string            // <<<--- 【新增】在最终使用的 Config 结构体中添加
+		CleanupCondition      *policy.Condition // 任务满足此条件时才会被清理
+		CrossSeedPolicy       string            // 与其他任务共用内容时的处理方式: skip、wait_all、together
+		HardlinkPolicy        string            // 内容存在硬链接时的处理方式: skip、keep_files、delete
+	}
+	Rules []Rule // 按配置文件中的顺序排列，第一条匹配的规则生效
+	Retry struct {
+		MaxAttempts int
+		BackoffBase time.Duration
+		BackoffMax  time.Duration
+	}
+	Daemon struct {
+		Enabled         bool
+		Workers         int
+		PollInterval    time.Duration
+		CleanupInterval time.Duration
+	}
+	Watch struct {
+		Enabled     bool
+		Interval    time.Duration
+		GracePeriod time.Duration
 	}
 	General struct {
 		LogLevel string
 	}
+	Trash struct {
+		Enabled   bool
+		Dir       string
+		Retention time.Duration // 0 表示不自动清空
+	}
+	DiskSpace struct {
+		Enabled   bool
+		Path      string // 用来计算剩余空间的下载目录，只清理内容位于其中的任务
+		HighWater uint64 // 剩余空间低于此值（字节）时开始清理
+		LowWater  uint64 // 清理到剩余空间不低于此值（字节）为止
+		Order     string // 清理顺序: oldest 或 least_active
+	}
 	Maintenance struct {
 		LogMaxSizeMB       int
 		LogMaxBackups      int
@@ -41,10 +120,48 @@ type Config struct {
 
 type rawConfig struct {
 	Uploader struct {
+		Backend       string `ini:"Backend"`
+		Quorum        string `ini:"Quorum"`
 		Path          string `ini:"Path"`
 		MyCloudFolder string `ini:"MyCloudFolder"`
+		RemotePath    string `ini:"Remote_Path"`
 		ExtraArgs     string `ini:"ExtraArgs"`
+		VerifyMD5     bool   `ini:"Verify_MD5"`
 	} `ini:"Uploader"`
+	Rclone struct {
+		Path       string `ini:"Path"`
+		Remote     string `ini:"Remote"`
+		ExtraArgs  string `ini:"ExtraArgs"`
+		VerifyHash bool   `ini:"Verify_Hash"`
+	} `ini:"Rclone"`
+	Local struct {
+		Root string `ini:"Root"`
+	} `ini:"Local"`
+	S3 struct {
+		Endpoint   string `ini:"Endpoint"`
+		Region     string `ini:"Region"`
+		Bucket     string `ini:"Bucket"`
+		AccessKey  string `ini:"Access_Key"`
+		SecretKey  string `ini:"Secret_Key"`
+		UseSSL     bool   `ini:"Use_SSL"`
+		PathStyle  bool   `ini:"Path_Style"`
+		PartSizeMB int    `ini:"Part_Size_MB"`
+	} `ini:"S3"`
+	WebDAV struct {
+		URL      string `ini:"URL"`
+		Username string `ini:"Username"`
+		Password string `ini:"Password"`
+	} `ini:"WebDAV"`
+	SFTP struct {
+		Host                  string `ini:"Host"`
+		Username              string `ini:"Username"`
+		Password              string `ini:"Password"`
+		PrivateKey            string `ini:"Private_Key"`
+		PrivateKeyPassphrase  string `ini:"Private_Key_Passphrase"`
+		KnownHosts            string `ini:"Known_Hosts"`
+		InsecureIgnoreHostKey bool   `ini:"Insecure_Ignore_Host_Key"`
+		Root                  string `ini:"Root"`
+	} `ini:"SFTP"`
 	QBittorrent struct {
 		Host     string `ini:"Host"`
 		Username string `ini:"Username"`
@@ -54,11 +171,44 @@ type rawConfig struct {
 		TargetRatio           float64 `ini:"Target_Ratio"`
 		TargetSeedingHours    int     `ini:"Target_Seeding_Hours"`
 		ActionAfterProcess    string  `ini:"Action_After_Process"`
+		ActionCategory        string  `ini:"Action_Category"`
+		ActionTag             string  `ini:"Action_Tag"`
 		Cleanup_Target_States string  `ini:"Cleanup_Target_States"` // <<<--- 【新增】在原始 rawConfig 结构体中添加
+		CleanupCondition      string  `ini:"Cleanup_Condition"`
+		CrossSeedPolicy       string  `ini:"Cross_Seed_Policy"`
+		HardlinkPolicy        string  `ini:"Hardlink_Policy"`
 	} `ini:"Seeding_Policy"`
+	Retry struct {
+		MaxAttempts        int `ini:"Max_Attempts"`
+		BackoffBaseMinutes int `ini:"Backoff_Base_Minutes"`
+		BackoffMaxMinutes  int `ini:"Backoff_Max_Minutes"`
+	} `ini:"Retry"`
+	Daemon struct {
+		Enabled                bool `ini:"Enabled"`
+		Workers                int  `ini:"Workers"`
+		PollIntervalSeconds    int  `ini:"Poll_Interval_Seconds"`
+		CleanupIntervalMinutes int  `ini:"Cleanup_Interval_Minutes"`
+	} `ini:"Daemon"`
+	Watch struct {
+		Enabled            bool `ini:"Enabled"`
+		IntervalSeconds    int  `ini:"Interval_Seconds"`
+		GracePeriodMinutes int  `ini:"Grace_Period_Minutes"`
+	} `ini:"Watch"`
 	General struct {
 		LogMode string `ini:"Log_Mode"`
 	} `ini:"General"`
+	Trash struct {
+		Enabled       bool   `ini:"Enabled"`
+		Dir           string `ini:"Dir"`
+		RetentionDays int    `ini:"Retention_Days"`
+	} `ini:"Trash"`
+	DiskSpace struct {
+		Enabled     bool    `ini:"Enabled"`
+		Path        string  `ini:"Path"`
+		HighWaterGB float64 `ini:"High_Water_GB"`
+		LowWaterGB  float64 `ini:"Low_Water_GB"`
+		Order       string  `ini:"Order"`
+	} `ini:"Disk_Space"`
 	Maintenance struct {
 		LogMaxSizeMB       int `ini:"Log_Max_Size_MB"`
 		LogMaxBackups      int `ini:"Log_Max_Backups"`
@@ -89,9 +239,64 @@ func Init() error {
 
 	Cfg = new(Config)
 	// ... (其他赋值不变)
+	Cfg.Uploader.Backends, err = parseBackends(rawCfg.Uploader.Backend)
+	if err != nil {
+		return fmt.Errorf("[Uploader] %w", err)
+	}
+	if len(Cfg.Uploader.Backends) == 0 {
+		Cfg.Uploader.Backends = []string{"baidupcs"}
+	}
+	quorum, err := parseQuorum(rawCfg.Uploader.Quorum, len(Cfg.Uploader.Backends))
+	if err != nil {
+		return fmt.Errorf("[Uploader] %w", err)
+	}
+	Cfg.Uploader.Quorum = quorum
 	Cfg.Uploader.Path = rawCfg.Uploader.Path
 	Cfg.Uploader.RemoteDir = rawCfg.Uploader.MyCloudFolder
+	Cfg.Uploader.RemotePath = strings.TrimSpace(rawCfg.Uploader.RemotePath)
+	if Cfg.Uploader.RemotePath == "" {
+		Cfg.Uploader.RemotePath = "{name}"
+	}
+	if err := remotepath.Validate(Cfg.Uploader.RemotePath); err != nil {
+		return fmt.Errorf("[Uploader] Remote_Path '%s' 无效: %w", Cfg.Uploader.RemotePath, err)
+	}
 	Cfg.Uploader.ExtraArgs = strings.Fields(rawCfg.Uploader.ExtraArgs)
+	Cfg.Uploader.VerifyMD5 = rawCfg.Uploader.VerifyMD5
+	Cfg.Rclone.Path = rawCfg.Rclone.Path
+	if Cfg.Rclone.Path == "" {
+		Cfg.Rclone.Path = "rclone"
+	}
+	Cfg.Rclone.Remote = rawCfg.Rclone.Remote
+	Cfg.Rclone.ExtraArgs = strings.Fields(rawCfg.Rclone.ExtraArgs)
+	Cfg.Rclone.VerifyHash = rawCfg.Rclone.VerifyHash
+	Cfg.Local.Root = rawCfg.Local.Root
+	Cfg.S3.Endpoint = rawCfg.S3.Endpoint
+	Cfg.S3.Region = rawCfg.S3.Region
+	Cfg.S3.Bucket = rawCfg.S3.Bucket
+	Cfg.S3.AccessKey = rawCfg.S3.AccessKey
+	Cfg.S3.SecretKey = rawCfg.S3.SecretKey
+	Cfg.S3.UseSSL = rawCfg.S3.UseSSL
+	Cfg.S3.PathStyle = rawCfg.S3.PathStyle
+	Cfg.S3.PartSizeMB = rawCfg.S3.PartSizeMB
+	if Cfg.S3.PartSizeMB <= 0 {
+		Cfg.S3.PartSizeMB = 64
+	}
+	Cfg.WebDAV.URL = rawCfg.WebDAV.URL
+	Cfg.WebDAV.Username = rawCfg.WebDAV.Username
+	Cfg.WebDAV.Password = rawCfg.WebDAV.Password
+	Cfg.SFTP.Host = rawCfg.SFTP.Host
+	Cfg.SFTP.Username = rawCfg.SFTP.Username
+	Cfg.SFTP.Password = rawCfg.SFTP.Password
+	Cfg.SFTP.PrivateKey = rawCfg.SFTP.PrivateKey
+	Cfg.SFTP.PrivateKeyPassphrase = rawCfg.SFTP.PrivateKeyPassphrase
+	Cfg.SFTP.KnownHosts = rawCfg.SFTP.KnownHosts
+	if Cfg.SFTP.KnownHosts == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			Cfg.SFTP.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+	}
+	Cfg.SFTP.InsecureIgnoreHostKey = rawCfg.SFTP.InsecureIgnoreHostKey
+	Cfg.SFTP.Root = rawCfg.SFTP.Root
 	Cfg.QBittorrent.Host = rawCfg.QBittorrent.Host
 	Cfg.QBittorrent.Username = rawCfg.QBittorrent.Username
 	Cfg.QBittorrent.Password = rawCfg.QBittorrent.Password
@@ -99,8 +304,84 @@ func Init() error {
 	// SeedingPolicy 部分
 	Cfg.SeedingPolicy.TargetRatio = rawCfg.SeedingPolicy.TargetRatio
 	Cfg.SeedingPolicy.TargetSeedingHours = rawCfg.SeedingPolicy.TargetSeedingHours
-	Cfg.SeedingPolicy.ActionAfterProcess = rawCfg.SeedingPolicy.ActionAfterProcess
+	Cfg.SeedingPolicy.ActionAfterProcess = strings.ToLower(strings.TrimSpace(rawCfg.SeedingPolicy.ActionAfterProcess))
+	if Cfg.SeedingPolicy.ActionAfterProcess == "" {
+		Cfg.SeedingPolicy.ActionAfterProcess = ActionDelete
+	}
+	Cfg.SeedingPolicy.ActionCategory = strings.TrimSpace(rawCfg.SeedingPolicy.ActionCategory)
+	Cfg.SeedingPolicy.ActionTag = strings.TrimSpace(rawCfg.SeedingPolicy.ActionTag)
+	if err := ValidateAction(Cfg.SeedingPolicy.ActionAfterProcess, Cfg.SeedingPolicy.ActionCategory, Cfg.SeedingPolicy.ActionTag); err != nil {
+		return fmt.Errorf("[Seeding_Policy] %w", err)
+	}
 	Cfg.SeedingPolicy.Cleanup_Target_States = rawCfg.SeedingPolicy.Cleanup_Target_States // <<<--- 【新增】将读取到的值赋给最终配置
+	conditionExpr := strings.TrimSpace(rawCfg.SeedingPolicy.CleanupCondition)
+	if conditionExpr == "" {
+		conditionExpr = defaultCleanupCondition(Cfg.SeedingPolicy.Cleanup_Target_States, Cfg.SeedingPolicy.TargetRatio, Cfg.SeedingPolicy.TargetSeedingHours)
+	}
+	if conditionExpr == "" {
+		return fmt.Errorf("[Seeding_Policy] 必须设置 Cleanup_Condition 或 Cleanup_Target_States")
+	}
+	Cfg.SeedingPolicy.CleanupCondition, err = policy.Parse(conditionExpr)
+	if err != nil {
+		return fmt.Errorf("[Seeding_Policy] 清理条件 '%s' 无效: %w", conditionExpr, err)
+	}
+
+	Cfg.SeedingPolicy.CrossSeedPolicy = strings.ToLower(strings.TrimSpace(rawCfg.SeedingPolicy.CrossSeedPolicy))
+	switch Cfg.SeedingPolicy.CrossSeedPolicy {
+	case "":
+		Cfg.SeedingPolicy.CrossSeedPolicy = "skip"
+	case "skip", "wait_all", "together":
+	default:
+		return fmt.Errorf("[Seeding_Policy] Cross_Seed_Policy 的值 '%s' 无效，只能是 skip、wait_all 或 together", rawCfg.SeedingPolicy.CrossSeedPolicy)
+	}
+	Cfg.SeedingPolicy.HardlinkPolicy = strings.ToLower(strings.TrimSpace(rawCfg.SeedingPolicy.HardlinkPolicy))
+	switch Cfg.SeedingPolicy.HardlinkPolicy {
+	case "":
+		Cfg.SeedingPolicy.HardlinkPolicy = "skip"
+	case "skip", "keep_files", "delete":
+	default:
+		return fmt.Errorf("[Seeding_Policy] Hardlink_Policy 的值 '%s' 无效，只能是 skip、keep_files 或 delete", rawCfg.SeedingPolicy.HardlinkPolicy)
+	}
+
+	// Rule 部分
+	Cfg.Rules, err = loadRules(iniCfg, rawCfg.Uploader.Quorum)
+	if err != nil {
+		return err
+	}
+
+	// Retry 部分
+	Cfg.Retry.MaxAttempts = rawCfg.Retry.MaxAttempts
+	if Cfg.Retry.MaxAttempts <= 0 {
+		Cfg.Retry.MaxAttempts = 5
+	}
+	Cfg.Retry.BackoffBase = time.Duration(rawCfg.Retry.BackoffBaseMinutes) * time.Minute
+	if Cfg.Retry.BackoffBase <= 0 {
+		Cfg.Retry.BackoffBase = 5 * time.Minute
+	}
+	Cfg.Retry.BackoffMax = time.Duration(rawCfg.Retry.BackoffMaxMinutes) * time.Minute
+	if Cfg.Retry.BackoffMax < Cfg.Retry.BackoffBase {
+		Cfg.Retry.BackoffMax = max(6*time.Hour, Cfg.Retry.BackoffBase)
+	}
+
+	// Daemon 部分
+	Cfg.Daemon.Enabled = rawCfg.Daemon.Enabled
+	Cfg.Daemon.Workers = rawCfg.Daemon.Workers
+	if Cfg.Daemon.Workers <= 0 {
+		Cfg.Daemon.Workers = 2
+	}
+	Cfg.Daemon.PollInterval = time.Duration(rawCfg.Daemon.PollIntervalSeconds) * time.Second
+	if Cfg.Daemon.PollInterval <= 0 {
+		Cfg.Daemon.PollInterval = 10 * time.Second
+	}
+	Cfg.Daemon.CleanupInterval = time.Duration(rawCfg.Daemon.CleanupIntervalMinutes) * time.Minute
+
+	// Watch 部分
+	Cfg.Watch.Enabled = rawCfg.Watch.Enabled
+	Cfg.Watch.Interval = time.Duration(rawCfg.Watch.IntervalSeconds) * time.Second
+	if Cfg.Watch.Interval <= 0 {
+		Cfg.Watch.Interval = 60 * time.Second
+	}
+	Cfg.Watch.GracePeriod = time.Duration(rawCfg.Watch.GracePeriodMinutes) * time.Minute
 
 	// ... (其他赋值不变)
 	switch strings.ToLower(rawCfg.General.LogMode) {
@@ -109,9 +390,163 @@ func Init() error {
 	default:
 		Cfg.General.LogLevel = "normal"
 	}
+	// Trash 部分
+	Cfg.Trash.Enabled = rawCfg.Trash.Enabled
+	Cfg.Trash.Dir = strings.TrimSpace(rawCfg.Trash.Dir)
+	if Cfg.Trash.Enabled && Cfg.Trash.Dir == "" {
+		return fmt.Errorf("[Trash] 启用回收站时必须设置 Dir")
+	}
+	if Cfg.Trash.Dir != "" {
+		if Cfg.Trash.Dir, err = filepath.Abs(Cfg.Trash.Dir); err != nil {
+			return fmt.Errorf("[Trash] Dir 无效: %w", err)
+		}
+	}
+	Cfg.Trash.Retention = time.Duration(rawCfg.Trash.RetentionDays) * 24 * time.Hour
+
+	// Disk_Space 部分
+	if err := parseDiskSpace(rawCfg); err != nil {
+		return fmt.Errorf("[Disk_Space] %w", err)
+	}
+
 	Cfg.Maintenance.LogMaxSizeMB = rawCfg.Maintenance.LogMaxSizeMB
 	Cfg.Maintenance.LogMaxBackups = rawCfg.Maintenance.LogMaxBackups
 	Cfg.Maintenance.DBKeepArchivedDays = rawCfg.Maintenance.DBKeepArchivedDays
 
 	return nil
 }
+
+// parseDiskSpace 读取按剩余空间清理的设置。
+func parseDiskSpace(rawCfg *rawConfig) error {
+	raw := rawCfg.DiskSpace
+	Cfg.DiskSpace.Enabled = raw.Enabled
+	if !raw.Enabled {
+		return nil
+	}
+	if Cfg.Trash.Enabled {
+		// 回收站必须和下载目录在同一个文件系统上，移入回收站并不会释放空间
+		return fmt.Errorf("不能与 [Trash] 同时启用：移入回收站不会释放下载目录所在磁盘的空间")
+	}
+	path := strings.TrimSpace(raw.Path)
+	if path == "" {
+		return fmt.Errorf("启用时必须设置 Path")
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("Path 无效: %w", err)
+	}
+	Cfg.DiskSpace.Path = path
+	if raw.HighWaterGB <= 0 {
+		return fmt.Errorf("High_Water_GB 必须大于 0")
+	}
+	if raw.LowWaterGB < raw.HighWaterGB {
+		return fmt.Errorf("Low_Water_GB (%g) 不能小于 High_Water_GB (%g)", raw.LowWaterGB, raw.HighWaterGB)
+	}
+	const gb = 1 << 30
+	Cfg.DiskSpace.HighWater = uint64(raw.HighWaterGB * gb)
+	Cfg.DiskSpace.LowWater = uint64(raw.LowWaterGB * gb)
+	switch order := strings.ToLower(strings.TrimSpace(raw.Order)); order {
+	case "", "oldest":
+		Cfg.DiskSpace.Order = "oldest"
+	case "least_active":
+		Cfg.DiskSpace.Order = order
+	default:
+		return fmt.Errorf("Order 的值 '%s' 无效，只能是 oldest 或 least_active", raw.Order)
+	}
+	return nil
+}
+
+// Action_After_Process 支持的取值。
+const (
+	ActionDelete          = "delete"            // 删除本地文件并从 qB 中移除任务
+	ActionRemoveKeepFiles = "remove_keep_files" // 仅从 qB 中移除任务，保留本地文件
+	ActionPause           = "pause"             // 删除本地文件并暂停任务
+	ActionDoNothing       = "do_nothing"        // 删除本地文件，不改动 qB 中的任务
+	ActionMoveToCategory  = "move_to_category"  // 删除本地文件并将任务移动到指定分类
+	ActionSetTag          = "set_tag"           // 删除本地文件并为任务添加标签
+)
+
+// ValidateAction 检查 Action_After_Process 及其参数是否合法，避免拼写错误被当成其他操作执行。
+func ValidateAction(action, category, tag string) error {
+	switch action {
+	case ActionDelete, ActionRemoveKeepFiles, ActionPause, ActionDoNothing:
+		return nil
+	case ActionMoveToCategory:
+		if category == "" {
+			return fmt.Errorf("Action_After_Process = %s 时必须设置 Action_Category", action)
+		}
+		return nil
+	case ActionSetTag:
+		if tag == "" {
+			return fmt.Errorf("Action_After_Process = %s 时必须设置 Action_Tag", action)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Action_After_Process 的值 '%s' 无效，只能是 delete、remove_keep_files、pause、do_nothing、move_to_category 或 set_tag", action)
+	}
+}
+
+// defaultCleanupCondition 在未设置 Cleanup_Condition 时，由旧的配置项拼出等价的条件表达式:
+// 状态满足 Cleanup_Target_States，并且（如果设置了）分享率或做种时长达到目标。
+func defaultCleanupCondition(targetStates string, targetRatio float64, targetSeedingHours int) string {
+	var states []string
+	for _, s := range strings.Split(targetStates, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			states = append(states, s)
+		}
+	}
+	var targets []string
+	if targetRatio > 0 {
+		targets = append(targets, fmt.Sprintf("ratio >= %g", targetRatio))
+	}
+	if targetSeedingHours > 0 {
+		targets = append(targets, fmt.Sprintf("seeding_time >= %dh", targetSeedingHours))
+	}
+
+	var parts []string
+	if len(states) > 0 {
+		parts = append(parts, fmt.Sprintf("state IN (%s)", strings.Join(states, ", ")))
+	}
+	if len(targets) > 0 {
+		parts = append(parts, "("+strings.Join(targets, " OR ")+")")
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// knownBackends 是程序支持的存储后端，与 scheduler 中创建后端的名称一致。
+var knownBackends = []string{"baidupcs", "rclone", "local", "s3", "webdav", "sftp"}
+
+// parseBackends 解析逗号分隔的后端列表，统一转为小写并去重。
+// 填写了未知的后端时返回错误，避免拼写错误直到上传时才被发现。
+func parseBackends(value string) ([]string, error) {
+	var backends []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if !slices.Contains(knownBackends, name) {
+			return nil, fmt.Errorf("Backend 中的 '%s' 不是可用的存储后端，只能是 %s", name, strings.Join(knownBackends, "、"))
+		}
+		seen[name] = true
+		backends = append(backends, name)
+	}
+	return backends, nil
+}
+
+// parseQuorum 将 Quorum 配置解析为需要成功的后端数量。
+// 支持 "all"（默认）、"any" 或 1 到后端总数之间的整数。
+func parseQuorum(value string, total int) (int, error) {
+	switch v := strings.ToLower(strings.TrimSpace(value)); v {
+	case "", "all":
+		return total, nil
+	case "any":
+		return 1, nil
+	default:
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > total {
+			return 0, fmt.Errorf("Quorum 的值 '%s' 无效，只能是 all、any 或 1 到 %d 之间的整数", value, total)
+		}
+		return n, nil
+	}
+}
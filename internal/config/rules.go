@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"qbuploader/internal/policy"
+	"qbuploader/internal/remotepath"
+
+	"gopkg.in/ini.v1"
+)
+
+// rulePrefix 是规则段的前缀，例如 [Rule:anime]。
+const rulePrefix = "Rule:"
+
+// Rule 是一条按分类、标签、Tracker 或保存路径匹配任务的规则，
+// 匹配到的任务使用规则中的设置覆盖全局设置。未填写的项沿用全局设置。
+type Rule struct {
+	Name string
+
+	// 匹配条件。同一项内填写多个值时满足任意一个即可，不同项之间需要同时满足。
+	Categories []string // qB 分类，完全匹配
+	Tags       []string // qB 标签，任务带有其中任意一个标签即可
+	Trackers   []string // Tracker 域名，同时匹配其子域名
+	SavePaths  []string // 保存路径前缀
+
+	// 覆盖项
+	SkipUpload       bool              // 为 true 时匹配的任务不会被上传，也不会被清理
+	RemoteDir        string            // 空表示沿用 [Uploader] MyCloudFolder
+	RemotePath       string            // 空表示沿用 [Uploader] Remote_Path
+	Backends         []string          // 空表示沿用 [Uploader] Backend
+	Quorum           int               // 与 Backends 对应的成功条件，Backends 为空时沿用全局
+	CleanupCondition *policy.Condition // nil 表示沿用全局清理条件
+	Action           string            // 空表示沿用全局 Action_After_Process
+	ActionCategory   string
+	ActionTag        string
+}
+
+// rawRule 对应 config.ini 中的一个 [Rule:名称] 段。
+type rawRule struct {
+	Category           string `ini:"Category"`
+	Tag                string `ini:"Tag"`
+	Tracker            string `ini:"Tracker"`
+	SavePath           string `ini:"Save_Path"`
+	Upload             string `ini:"Upload"`
+	MyCloudFolder      string `ini:"MyCloudFolder"`
+	RemotePath         string `ini:"Remote_Path"`
+	Backend            string `ini:"Backend"`
+	Quorum             string `ini:"Quorum"`
+	CleanupCondition   string `ini:"Cleanup_Condition"`
+	ActionAfterProcess string `ini:"Action_After_Process"`
+	ActionCategory     string `ini:"Action_Category"`
+	ActionTag          string `ini:"Action_Tag"`
+}
+
+// loadRules 读取所有 [Rule:名称] 段。globalQuorum 是 [Uploader] Quorum 的原始值，
+// 规则只覆盖 Backend 而没有填写 Quorum 时按它计算成功条件。
+func loadRules(iniCfg *ini.File, globalQuorum string) ([]Rule, error) {
+	var rules []Rule
+	for _, section := range iniCfg.Sections() {
+		if !strings.HasPrefix(section.Name(), rulePrefix) {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(section.Name(), rulePrefix))
+		raw := new(rawRule)
+		if err := section.MapTo(raw); err != nil {
+			return nil, fmt.Errorf("解析 [%s] 失败: %w", section.Name(), err)
+		}
+		rule, err := parseRule(name, raw, globalQuorum)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %w", section.Name(), err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(name string, raw *rawRule, globalQuorum string) (Rule, error) {
+	rule := Rule{
+		Name:       name,
+		Categories: splitList(raw.Category),
+		Tags:       splitList(raw.Tag),
+		Trackers:   splitList(strings.ToLower(raw.Tracker)),
+		RemoteDir:  strings.TrimSpace(raw.MyCloudFolder),
+		RemotePath: strings.TrimSpace(raw.RemotePath),
+	}
+	backends, err := parseBackends(raw.Backend)
+	if err != nil {
+		return rule, err
+	}
+	rule.Backends = backends
+	for _, p := range splitList(raw.SavePath) {
+		rule.SavePaths = append(rule.SavePaths, filepath.Clean(p))
+	}
+	if len(rule.Categories)+len(rule.Tags)+len(rule.Trackers)+len(rule.SavePaths) == 0 {
+		return rule, fmt.Errorf("至少需要填写 Category、Tag、Tracker 或 Save_Path 中的一项")
+	}
+
+	if rule.RemotePath != "" {
+		if err := remotepath.Validate(rule.RemotePath); err != nil {
+			return rule, fmt.Errorf("Remote_Path '%s' 无效: %w", rule.RemotePath, err)
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(raw.Upload)) {
+	case "", "true", "yes", "1":
+	case "false", "no", "0", "never":
+		rule.SkipUpload = true
+	default:
+		return rule, fmt.Errorf("Upload 的值 '%s' 无效，只能是 true 或 false", raw.Upload)
+	}
+
+	if len(rule.Backends) > 0 {
+		quorum := raw.Quorum
+		if strings.TrimSpace(quorum) == "" {
+			quorum = globalQuorum
+		}
+		n, err := parseQuorum(quorum, len(rule.Backends))
+		if err != nil {
+			return rule, err
+		}
+		rule.Quorum = n
+	} else if strings.TrimSpace(raw.Quorum) != "" {
+		return rule, fmt.Errorf("只有同时填写 Backend 时才能设置 Quorum")
+	}
+
+	if expr := strings.TrimSpace(raw.CleanupCondition); expr != "" {
+		condition, err := policy.Parse(expr)
+		if err != nil {
+			return rule, fmt.Errorf("清理条件 '%s' 无效: %w", expr, err)
+		}
+		rule.CleanupCondition = condition
+	}
+
+	rule.Action = strings.ToLower(strings.TrimSpace(raw.ActionAfterProcess))
+	rule.ActionCategory = strings.TrimSpace(raw.ActionCategory)
+	rule.ActionTag = strings.TrimSpace(raw.ActionTag)
+	if rule.Action != "" {
+		if err := ValidateAction(rule.Action, rule.ActionCategory, rule.ActionTag); err != nil {
+			return rule, err
+		}
+	}
+	return rule, nil
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项。
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -14,29 +14,19 @@ import (
 
 var DB *sql.DB
 
-const (
-	createTableSQL = `
-	CREATE TABLE IF NOT EXISTS tasks (
-		info_hash     TEXT PRIMARY KEY,
-		torrent_name  TEXT,
-		upload_status TEXT NOT NULL DEFAULT 'pending',
-		message       TEXT,
-		created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);`
-	createTriggerSQL = `
-	CREATE TRIGGER IF NOT EXISTS update_tasks_updated_at
-	AFTER UPDATE ON tasks FOR EACH ROW
-	BEGIN
-		UPDATE tasks SET updated_at = CURRENT_TIMESTAMP WHERE info_hash = OLD.info_hash;
-	END;`
-)
-
 type Task struct {
 	InfoHash     string
 	TorrentName  string
 	UploadStatus string
 	Message      sql.NullString
+	LocalPath    sql.NullString
+	RemotePath   sql.NullString // 实际使用的上传目录（传给 Backend.Upload 的 remoteDir）
+	Backend      sql.NullString // 上传时使用的后端，逗号分隔
+	SizeBytes    sql.NullInt64  // 上传时本地内容的总字节数
+	FileCount    sql.NullInt64  // 上传时本地内容的文件数
+	TrashPath    sql.NullString // 本地内容在回收站中的位置，为空表示不在回收站
+	Attempts     int
+	NextRetryAt  sql.NullTime
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
@@ -45,13 +35,10 @@ func Init() error {
 	log := logger.Log
 	log.Debug("正在初始化数据库模块...")
 
-	wd, err := os.Getwd()
+	dbPath, err := databasePath()
 	if err != nil {
-		return fmt.Errorf("无法获取当前工作目录: %w", err)
+		return err
 	}
-	dbPath := filepath.Join(wd, "database.db")
-	log.Debugf("数据库文件路径: %s", dbPath)
-
 	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		return fmt.Errorf("打开数据库失败: %w", err)
@@ -60,15 +47,56 @@ func Init() error {
 		return fmt.Errorf("连接数据库失败: %w", err)
 	}
 
-	log.Debug("正在检查并创建数据表...")
-	if _, err = db.Exec(createTableSQL); err != nil {
-		return fmt.Errorf("创建 'tasks' 表失败: %w", err)
-	}
-	if _, err = db.Exec(createTriggerSQL); err != nil {
-		return fmt.Errorf("创建 'updated_at' 触发器失败: %w", err)
+	log.Debug("正在检查数据库结构...")
+	if err = migrate(db, dbPath); err != nil {
+		db.Close()
+		return err
 	}
 
 	DB = db
 	log.Debug("数据库初始化成功！")
 	return nil
 }
+
+// InitReadOnly 以只读方式打开数据库，供演练模式使用：不会创建数据库、升级结构或生成备份。
+// 数据库不存在或版本与程序不一致时返回错误，需要先正常运行一次程序完成初始化或升级。
+func InitReadOnly() error {
+	log := logger.Log
+	log.Debug("正在以只读方式打开数据库...")
+
+	dbPath, err := databasePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("数据库文件不可用: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	current, err := schemaVersion(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if latest := latestVersion(); current != latest {
+		db.Close()
+		return fmt.Errorf("数据库版本 (%d) 与当前程序支持的版本 (%d) 不一致，只读模式下不会升级数据库，请先正常运行一次 qbuploader", current, latest)
+	}
+
+	DB = db
+	log.Debug("数据库已以只读方式打开。")
+	return nil
+}
+
+// databasePath 返回数据库文件的路径（当前工作目录下的 database.db）。
+func databasePath() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("无法获取当前工作目录: %w", err)
+	}
+	dbPath := filepath.Join(wd, "database.db")
+	logger.Log.Debugf("数据库文件路径: %s", dbPath)
+	return dbPath, nil
+}
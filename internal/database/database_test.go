@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTempDir 切换到临时目录，Init 和 InitReadOnly 会在其中使用 database.db。
+func useTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Cleanup(func() {
+		if DB != nil {
+			DB.Close()
+			DB = nil
+		}
+	})
+	return dir
+}
+
+// entries 返回目录中的文件名。
+func entries(t *testing.T, dir string) []string {
+	t.Helper()
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range list {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestInitReadOnly(t *testing.T) {
+	dir := useTempDir(t)
+	if err := InitReadOnly(); err == nil {
+		t.Fatal("数据库不存在时 InitReadOnly 应返回错误")
+	}
+	if names := entries(t, dir); len(names) > 0 {
+		t.Errorf("InitReadOnly 不应创建任何文件，目录中有 %v", names)
+	}
+
+	// 版本 1 的旧数据库：只读模式拒绝打开，不会升级也不会备份
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE tasks (info_hash TEXT PRIMARY KEY, torrent_name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := InitReadOnly(); err == nil || !strings.Contains(err.Error(), "只读模式下不会升级数据库") {
+		t.Errorf("数据库需要升级时 InitReadOnly 的错误为 %v", err)
+	}
+	if names := entries(t, dir); len(names) != 1 {
+		t.Errorf("InitReadOnly 不应修改数据库或生成备份，目录中有 %v", names)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	DB.Close()
+	DB = nil
+	if err := InitReadOnly(); err != nil {
+		t.Fatalf("InitReadOnly: %v", err)
+	}
+	if _, err := DB.Exec(`INSERT INTO tasks (info_hash) VALUES ('abc')`); err == nil {
+		t.Error("只读打开的数据库不应允许写入")
+	}
+}
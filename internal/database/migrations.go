@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"qbuploader/internal/logger"
+)
+
+// migration 是一次数据库结构变更。执行后在 schema_version 中记录版本号。
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations 按版本号顺序排列，已经发布的迁移不能再修改，只能在末尾追加新的迁移。
+//
+// 版本 1-5 之前由启动时自动补列的逻辑维护，旧数据库可能处于其中任意一个中间状态，
+// 因此这几个迁移需要容忍表和列已经存在；之后的迁移可以直接执行。
+var migrations = []migration{
+	{1, "创建 tasks 表", execAll(`
+		CREATE TABLE IF NOT EXISTS tasks (
+			info_hash     TEXT PRIMARY KEY,
+			torrent_name  TEXT,
+			upload_status TEXT NOT NULL DEFAULT 'pending',
+			message       TEXT,
+			created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`, `
+		CREATE TRIGGER IF NOT EXISTS update_tasks_updated_at
+		AFTER UPDATE ON tasks FOR EACH ROW
+		BEGIN
+			UPDATE tasks SET updated_at = CURRENT_TIMESTAMP WHERE info_hash = OLD.info_hash;
+		END`)},
+	{2, "为上传队列与失败重试增加列", addColumns("tasks",
+		"local_path TEXT",
+		"attempts INTEGER NOT NULL DEFAULT 0",
+		"next_retry_at DATETIME")},
+	{3, "创建 task_destinations 表", execAll(`
+		CREATE TABLE IF NOT EXISTS task_destinations (
+			info_hash  TEXT NOT NULL,
+			backend    TEXT NOT NULL,
+			status     TEXT NOT NULL DEFAULT 'pending',
+			message    TEXT,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (info_hash, backend)
+		)`)},
+	{4, "为上传租约增加列", addColumns("tasks",
+		"lease_owner TEXT",
+		"heartbeat_at DATETIME")},
+	{5, "记录上传目录、后端与内容大小", addColumns("tasks",
+		"remote_path TEXT",
+		"backend TEXT",
+		"size_bytes INTEGER",
+		"file_count INTEGER")},
+	{6, "创建 task_files 表", execAll(`
+		CREATE TABLE task_files (
+			info_hash  TEXT NOT NULL,
+			backend    TEXT NOT NULL,
+			path       TEXT NOT NULL,
+			size_bytes INTEGER NOT NULL,
+			status     TEXT NOT NULL DEFAULT 'pending',
+			message    TEXT,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (info_hash, backend, path)
+		)`)},
+	{7, "记录移入回收站的本地文件", execAll(
+		`ALTER TABLE tasks ADD COLUMN trash_path TEXT`,
+		`ALTER TABLE tasks ADD COLUMN trashed_at DATETIME`)},
+	{8, "创建 locks 表", execAll(`
+		CREATE TABLE locks (
+			name         TEXT PRIMARY KEY,
+			owner        TEXT NOT NULL,
+			acquired_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			heartbeat_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)},
+}
+
+// execAll 返回一个依次执行给定 SQL 语句的迁移函数。
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns 返回一个为表添加列的迁移函数，已经存在的列会被跳过。
+// definitions 的格式为 "列名 类型 约束"。
+func addColumns(table string, definitions ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		existing, err := columnNames(tx, table)
+		if err != nil {
+			return err
+		}
+		for _, def := range definitions {
+			var column string
+			fmt.Sscan(def, &column)
+			if existing[column] {
+				continue
+			}
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, def)); err != nil {
+				return fmt.Errorf("为 '%s' 表添加列 '%s' 失败: %w", table, column, err)
+			}
+		}
+		return nil
+	}
+}
+
+func columnNames(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("读取 '%s' 表结构失败: %w", table, err)
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, fmt.Errorf("读取 '%s' 表结构失败: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// latestVersion 返回当前程序支持的最新数据库版本。
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrationBusyTimeout 是等待其他进程完成数据库升级的最长时间（毫秒）。
+const migrationBusyTimeout = 60000
+
+// migrate 将数据库升级到最新版本。升级前会把旧数据库备份到 dbPath 旁边；
+// 数据库版本比程序支持的更新时拒绝启动，避免旧程序写坏新版本的数据。
+//
+// 多个进程可能同时启动，因此检查版本、备份与全部迁移都在同一个 BEGIN IMMEDIATE 事务中完成：
+// 后启动的进程会等待先启动的进程升级完毕，再读到最新的版本号，不会重复执行迁移。
+func migrate(db *sql.DB, dbPath string) error {
+	log := logger.Log
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := latestVersion()
+	log.Debugf("数据库版本: %d，程序支持的版本: %d", current, latest)
+	if current > latest {
+		return fmt.Errorf("数据库版本 (%d) 比当前程序支持的版本 (%d) 更新，请升级 qbuploader 后再运行", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	// database/sql 的事务没有 IMMEDIATE 选项，单独打开一个连接并通过 _txlock 让 Begin 直接取得写锁
+	lockDB, err := sql.Open("sqlite3", fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, migrationBusyTimeout))
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer lockDB.Close()
+	tx, err := lockDB.Begin()
+	if err != nil {
+		return fmt.Errorf("锁定数据库以进行升级失败: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 等待写锁期间其他进程可能已经完成了升级，必须在事务内重新读取版本号
+	if current, err = schemaVersion(tx); err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("数据库版本 (%d) 比当前程序支持的版本 (%d) 更新，请升级 qbuploader 后再运行", current, latest)
+	}
+	if current == latest {
+		log.Debug("数据库已由其他进程升级到最新版本。")
+		return nil
+	}
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version     INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("创建 'schema_version' 表失败: %w", err)
+	}
+	var tables int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name <> 'schema_version'`).Scan(&tables); err != nil {
+		return fmt.Errorf("读取数据库结构失败: %w", err)
+	}
+	if tables > 0 {
+		backupPath := fmt.Sprintf("%s.v%d-%s-%d.bak", dbPath, current, time.Now().Format("20060102-150405"), os.Getpid())
+		log.Infof("数据库需要从版本 %d 升级到 %d，正在备份到: %s", current, latest, backupPath)
+		// VACUUM INTO 不能在事务中执行，改用另一个连接读取；WAL 模式下持有写锁不影响其他连接读取
+		if err := backup(db, backupPath); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if tables > 0 {
+			log.Infof("正在升级数据库到版本 %d: %s", m.version, m.description)
+		} else {
+			log.Debugf("正在初始化数据库到版本 %d: %s", m.version, m.description)
+		}
+		if err := m.up(tx); err != nil {
+			return fmt.Errorf("升级数据库到版本 %d (%s) 失败: %w", m.version, m.description, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, description) VALUES (?, ?)`, m.version, m.description); err != nil {
+			return fmt.Errorf("记录数据库版本 %d 失败: %w", m.version, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交数据库升级失败: %w", err)
+	}
+	return nil
+}
+
+// schemaVersion 返回数据库当前的版本号，schema_version 表不存在时视为 0。
+func schemaVersion(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (int, error) {
+	var exists, current int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("读取数据库版本失败: %w", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+	if err := q.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return 0, fmt.Errorf("读取数据库版本失败: %w", err)
+	}
+	return current, nil
+}
+
+// backup 使用 VACUUM INTO 生成数据库的一致性副本（包含 WAL 中尚未合并的内容）。
+func backup(db *sql.DB, backupPath string) error {
+	if _, err := os.Stat(backupPath); err == nil {
+		return fmt.Errorf("备份文件 '%s' 已存在", backupPath)
+	}
+	if _, err := db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return fmt.Errorf("备份数据库失败: %w", err)
+	}
+	return nil
+}
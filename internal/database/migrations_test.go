@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T, dbPath string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+}
+
+// backups 返回 dbPath 旁边的备份文件。
+func backups(t *testing.T, dbPath string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(dbPath + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// checkLatestSchema 检查数据库已升级到最新版本，并且包含所有迁移创建的表和列。
+func checkLatestSchema(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if v, err := schemaVersion(db); err != nil || v != latestVersion() {
+		t.Fatalf("数据库版本为 %d, %v，应为 %d", v, err, latestVersion())
+	}
+	var recorded int
+	db.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&recorded)
+	if recorded != len(migrations) {
+		t.Errorf("schema_version 中有 %d 条记录，应为 %d 条", recorded, len(migrations))
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	want := map[string][]string{
+		"tasks":             {"info_hash", "local_path", "attempts", "lease_owner", "remote_path", "size_bytes", "trash_path", "trashed_at"},
+		"task_destinations": {"info_hash", "backend", "status"},
+		"task_files":        {"info_hash", "backend", "path", "size_bytes", "status"},
+		"locks":             {"name", "owner", "heartbeat_at"},
+	}
+	for table, columns := range want {
+		names, err := columnNames(tx, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range columns {
+			if !names[c] {
+				t.Errorf("表 %s 缺少列 %s", table, c)
+			}
+		}
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "database.db")
+	db := openTestDB(t, dbPath)
+	if err := migrate(db, dbPath); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	checkLatestSchema(t, db)
+	// 新建的数据库没有需要备份的内容
+	if found := backups(t, dbPath); len(found) > 0 {
+		t.Errorf("新建数据库时生成了备份: %v", found)
+	}
+	// 已是最新版本时什么都不做
+	if err := migrate(db, dbPath); err != nil {
+		t.Errorf("再次 migrate: %v", err)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "database.db")
+	db := openTestDB(t, dbPath)
+	// 引入迁移之前的版本启动时自动补列，可能只补了一部分
+	mustExec(t, db, `CREATE TABLE tasks (
+			info_hash     TEXT PRIMARY KEY,
+			torrent_name  TEXT,
+			upload_status TEXT NOT NULL DEFAULT 'pending',
+			message       TEXT,
+			created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			local_path    TEXT,
+			lease_owner   TEXT
+		)`,
+		`INSERT INTO tasks (info_hash, torrent_name, upload_status, local_path) VALUES ('abc', 'Show', 'success', '/downloads/Show')`)
+
+	if err := migrate(db, dbPath); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	checkLatestSchema(t, db)
+	var name, status string
+	if err := db.QueryRow(`SELECT torrent_name, upload_status FROM tasks WHERE info_hash = 'abc'`).Scan(&name, &status); err != nil || name != "Show" || status != "success" {
+		t.Errorf("升级后的任务为 %q, %q, %v", name, status, err)
+	}
+
+	// 升级前的数据库被备份，备份中是旧的结构和数据
+	found := backups(t, dbPath)
+	if len(found) != 1 || !strings.Contains(found[0], ".v0-") {
+		t.Fatalf("备份文件为 %v，应有一个版本 0 的备份", found)
+	}
+	old := openTestDB(t, found[0])
+	if v, _ := schemaVersion(old); v != 0 {
+		t.Errorf("备份的数据库版本为 %d，应为 0", v)
+	}
+	if err := old.QueryRow(`SELECT torrent_name FROM tasks WHERE info_hash = 'abc'`).Scan(&name); err != nil || name != "Show" {
+		t.Errorf("备份中的任务为 %q, %v", name, err)
+	}
+}
+
+func TestMigrateFromIntermediateVersion(t *testing.T) {
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	// 从每一个旧版本开始升级，确保每个迁移都能在它之前的结构上执行
+	for from := 1; from < len(saved); from++ {
+		t.Run(fmt.Sprintf("v%d", from), func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "database.db")
+			db := openTestDB(t, dbPath)
+			migrations = saved[:from]
+			err := migrate(db, dbPath)
+			migrations = saved
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			mustExec(t, db, `INSERT INTO tasks (info_hash, torrent_name) VALUES ('abc', 'Show')`)
+
+			if err := migrate(db, dbPath); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			checkLatestSchema(t, db)
+			if found := backups(t, dbPath); len(found) != 1 || !strings.Contains(found[0], fmt.Sprintf(".v%d-", from)) {
+				t.Errorf("备份文件为 %v，应有一个版本 %d 的备份", found, from)
+			}
+		})
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "database.db")
+	db := openTestDB(t, dbPath)
+	if err := migrate(db, dbPath); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	mustExec(t, db, `INSERT INTO schema_version (version, description) VALUES (100, '未来的版本')`)
+
+	err := migrate(db, dbPath)
+	if err == nil || !strings.Contains(err.Error(), "请升级 qbuploader") {
+		t.Errorf("数据库版本更新时 migrate 的错误为 %v", err)
+	}
+	if found := backups(t, dbPath); len(found) > 0 {
+		t.Errorf("拒绝升级时生成了备份: %v", found)
+	}
+}
@@ -0,0 +1,260 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/logger"
+	"qbuploader/internal/storage"
+)
+
+// tempSuffix 是复制过程中临时文件的后缀，复制完成后再重命名为正式文件名。
+const tempSuffix = ".qbuploader-tmp"
+
+// Uploader 将内容复制到本地或已挂载的目录（NAS、移动硬盘等），实现了 storage.Backend 接口。
+type Uploader struct {
+	root string
+}
+
+var (
+	_ storage.Backend          = (*Uploader)(nil)
+	_ storage.Verifier         = (*Uploader)(nil)
+	_ storage.DirTimesRestorer = (*Uploader)(nil)
+)
+
+// NewUploader 创建一个新的本地目录 Uploader 实例。
+func NewUploader() (*Uploader, error) {
+	if config.Cfg.Local.Root == "" {
+		return nil, fmt.Errorf("[Local] 中的 Root 不能为空")
+	}
+	return &Uploader{
+		root: config.Cfg.Local.Root,
+	}, nil
+}
+
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "local"
+}
+
+// Upload 将文件或目录逐个复制到 remoteDir 下。
+// 每个文件先写入临时文件，完成后再重命名，并保留原有的修改时间。
+// 目标中已存在且大小、修改时间都相同的文件会被跳过，因此中断后可以直接重试。
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
+	log := logger.Log
+
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	destDir := u.fsPath(remoteDir)
+	log.Infof("  -> 正在复制: %s -> %s", localPath, destDir)
+
+	for _, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, filepath.FromSlash(lf.RelPath))
+		if info, err := os.Stat(dest); err == nil && info.Size() == lf.Size && info.ModTime().Equal(lf.ModTime) {
+			log.Debugf("  -> 目标已存在且一致，跳过: %s", dest)
+			continue
+		}
+		if err := copyFile(ctx, lf, dest); err != nil {
+			return fmt.Errorf("复制 '%s' 失败: %w", lf.RelPath, err)
+		}
+		log.Debugf("  -> 已复制: %s", dest)
+	}
+
+	// 目录的修改时间会因为写入子项而改变，最后统一恢复。
+	return u.RestoreDirTimes(ctx, localPath, remoteDir)
+}
+
+// RestoreDirTimes 将 remoteDir 下与 localPath 同名的目录树的修改时间恢复为与本地一致。
+// localPath 是单个文件时没有需要恢复的目录。
+func (u *Uploader) RestoreDirTimes(ctx context.Context, localPath, remoteDir string) error {
+	return restoreDirTimes(localPath, filepath.Join(u.fsPath(remoteDir), filepath.Base(localPath)))
+}
+
+// Exists 判断目标路径是否存在。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验目标路径: %s", u.fsPath(remotePath))
+	_, err := os.Stat(u.fsPath(remotePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stat 获取目标路径的信息。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	info, err := os.Stat(u.fsPath(remotePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toFileInfo(remotePath, info), nil
+}
+
+// Delete 删除目标文件或目录。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	return os.RemoveAll(u.fsPath(remotePath))
+}
+
+// List 列出目标目录下的直接子项（不包含未完成的临时文件）。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	dirEntries, err := os.ReadDir(u.fsPath(remoteDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", remoteDir, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []storage.FileInfo
+	for _, d := range dirEntries {
+		if filepath.Ext(d.Name()) == tempSuffix {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *toFileInfo(storage.JoinRemote(remoteDir, d.Name()), info))
+	}
+	return entries, nil
+}
+
+// Verify 逐个比对本地文件与目标文件的大小和 SHA-256。
+func (u *Uploader) Verify(ctx context.Context, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	destDir := u.fsPath(remoteDir)
+	results := make([]error, len(files))
+	for i, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		results[i] = compareFile(lf, filepath.Join(destDir, filepath.FromSlash(lf.RelPath)))
+	}
+	logger.Log.Debugf("  -> 本地目录校验完成，共比对 %d 个文件。", len(files))
+	return results, nil
+}
+
+// compareFile 比对一个本地文件与目标文件的大小和 SHA-256。
+func compareFile(lf storage.LocalFile, dest string) error {
+	info, err := os.Stat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("目标缺少文件 '%s': %w", lf.RelPath, storage.ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() != lf.Size {
+		return fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 目标 %d): %w", lf.RelPath, lf.Size, info.Size(), storage.ErrMismatch)
+	}
+	srcHash, err := storage.HashFile(lf.AbsPath, "sha256")
+	if err != nil {
+		return fmt.Errorf("计算本地文件 '%s' 的 SHA-256 失败: %w", lf.RelPath, err)
+	}
+	destHash, err := storage.HashFile(dest, "sha256")
+	if err != nil {
+		return fmt.Errorf("计算目标文件 '%s' 的 SHA-256 失败: %w", lf.RelPath, err)
+	}
+	if srcHash != destHash {
+		return fmt.Errorf("文件 '%s' 的 SHA-256 不一致: %w", lf.RelPath, storage.ErrMismatch)
+	}
+	return nil
+}
+
+// fsPath 将远程路径转换为本地文件系统路径。
+func (u *Uploader) fsPath(remotePath string) string {
+	return filepath.Join(u.root, filepath.FromSlash(remotePath))
+}
+
+// copyFile 以“临时文件 + 重命名”的方式复制单个文件，并保留修改时间。
+func copyFile(ctx context.Context, lf storage.LocalFile, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	src, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := dest + tempSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, &ctxReader{ctx: ctx, r: src})
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, lf.ModTime, lf.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// restoreDirTimes 将目标目录树的修改时间恢复为与源目录一致。目标中不存在的目录（其中的文件都没有上传）会被跳过。
+func restoreDirTimes(srcRoot, destRoot string) error {
+	return filepath.WalkDir(srcRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcRoot, p)
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(filepath.Join(destRoot, rel), info.ModTime(), info.ModTime()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+func toFileInfo(remotePath string, info fs.FileInfo) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:    remotePath,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+	}
+}
+
+// ctxReader 在每次读取前检查 context，使大文件复制可以被及时取消。
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,241 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+)
+
+func newTestUploader(t *testing.T) *Uploader {
+	t.Helper()
+	config.Cfg = new(config.Config)
+	config.Cfg.Local.Root = t.TempDir()
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func writeFile(t *testing.T, name, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newShow 创建一个带子目录的任务内容，文件和目录都使用固定的修改时间。
+func newShow(t *testing.T) (dir string, modTime time.Time) {
+	t.Helper()
+	dir = filepath.Join(t.TempDir(), "Show")
+	modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), "episode one", modTime)
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), "<episodedetails/>", modTime)
+	for _, d := range []string{filepath.Join(dir, "Extras"), dir} {
+		if err := os.Chtimes(d, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, modTime
+}
+
+// verify 校验 localPath 中的所有文件，返回各文件不一致的原因（合并为一个错误）。
+func verify(u *Uploader, localPath, remoteDir string) error {
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	results, err := u.Verify(context.Background(), files, remoteDir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(results...)
+}
+
+// checkModTimes 检查目标中每个文件和目录的修改时间都为 want。
+func checkModTimes(t *testing.T, root string, want time.Time) {
+	t.Helper()
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("'%s' 的修改时间为 %v，应为 %v", p, info.ModTime(), want)
+		}
+		return nil
+	})
+}
+
+// tempFiles 返回 root 下残留的临时文件。
+func tempFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var found []string
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(p, tempSuffix) {
+			found = append(found, p)
+		}
+		return err
+	})
+	return found
+}
+
+func TestUploadAndVerify(t *testing.T) {
+	u := newTestUploader(t)
+	dir, modTime := newShow(t)
+
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	dest := u.fsPath("tv/Show")
+	if data, err := os.ReadFile(filepath.Join(dest, "Extras", "info.nfo")); err != nil || string(data) != "<episodedetails/>" {
+		t.Errorf("info.nfo 的内容为 %q, %v", data, err)
+	}
+	checkModTimes(t, dest, modTime)
+	if found := tempFiles(t, u.root); len(found) > 0 {
+		t.Errorf("上传后残留了临时文件: %v", found)
+	}
+	if err := verify(u, dir, "tv"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if info, err := u.Stat(context.Background(), "tv/Show/ep1.mkv"); err != nil || info.Size != 11 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(dest string) error
+		want   error
+	}{
+		// 大小相同但内容不同，只有比对 SHA-256 才能发现
+		{"内容不同", func(dest string) error { return os.WriteFile(dest, []byte("EPISODE ONE"), 0o644) }, storage.ErrMismatch},
+		{"大小不同", func(dest string) error { return os.WriteFile(dest, []byte("episode"), 0o644) }, storage.ErrMismatch},
+		{"缺少文件", os.Remove, storage.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestUploader(t)
+			dir, _ := newShow(t)
+			if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if err := tt.change(u.fsPath("tv/Show/ep1.mkv")); err != nil {
+				t.Fatal(err)
+			}
+			if err := verify(u, dir, "tv"); !errors.Is(err, tt.want) {
+				t.Errorf("Verify 的错误为 %v，应为 %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	u := newTestUploader(t)
+	if _, err := u.Stat(context.Background(), "tv/Show"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Stat 的错误为 %v，应为 ErrNotFound", err)
+	}
+	if _, err := u.List(context.Background(), "tv"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("List 的错误为 %v，应为 ErrNotFound", err)
+	}
+	if exists, err := u.Exists(context.Background(), "tv/Show"); exists || err != nil {
+		t.Errorf("Exists = %v, %v，应为 false, nil", exists, err)
+	}
+}
+
+func TestUploadSkipsUnchanged(t *testing.T) {
+	u := newTestUploader(t)
+	dir, _ := newShow(t)
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	// 目标文件大小和修改时间都没变时不会重新复制，这里改动内容用来判断是否被覆盖
+	dest := u.fsPath("tv/Show/ep1.mkv")
+	info, _ := os.Stat(dest)
+	writeFile(t, dest, "EPISODE ONE", info.ModTime())
+
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "EPISODE ONE" {
+		t.Errorf("大小和修改时间一致的文件被重新复制了")
+	}
+}
+
+func TestUploadCanceled(t *testing.T) {
+	u := newTestUploader(t)
+	dir, _ := newShow(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	lf := storage.LocalFile{AbsPath: filepath.Join(dir, "ep1.mkv"), RelPath: "Show/ep1.mkv", Size: 11}
+	dest := u.fsPath("tv/Show/ep1.mkv")
+	if err := copyFile(ctx, lf, dest); !errors.Is(err, context.Canceled) {
+		t.Fatalf("copyFile 的错误为 %v，应为 context.Canceled", err)
+	}
+	if found := tempFiles(t, u.root); len(found) > 0 {
+		t.Errorf("复制被取消后残留了临时文件: %v", found)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("复制被取消后目标文件存在: %v", err)
+	}
+
+	// 上次运行被强制结束时可能留下临时文件，List 不应返回它们
+	writeFile(t, dest+tempSuffix, "epi", time.Now())
+	entries, err := u.List(context.Background(), "tv/Show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("List 返回了临时文件: %+v", entries)
+	}
+}
+
+func TestRestoreDirTimesPerFile(t *testing.T) {
+	u := newTestUploader(t)
+	dir, modTime := newShow(t)
+	files, err := storage.WalkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调度器逐个文件上传，每次 Upload 只能看到一个文件
+	for _, lf := range files {
+		if err := u.Upload(context.Background(), lf.AbsPath, path.Join("tv", path.Dir(lf.RelPath))); err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+	}
+	if err := u.RestoreDirTimes(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("RestoreDirTimes: %v", err)
+	}
+	checkModTimes(t, u.fsPath("tv/Show"), modTime)
+
+	// 只上传了部分文件时，目标中不存在的目录会被跳过
+	u = newTestUploader(t)
+	if err := u.Upload(context.Background(), filepath.Join(dir, "ep1.mkv"), "tv/Show"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := u.RestoreDirTimes(context.Background(), dir, "tv"); err != nil {
+		t.Errorf("RestoreDirTimes: %v", err)
+	}
+	checkModTimes(t, u.fsPath("tv/Show"), modTime)
+}
@@ -0,0 +1,391 @@
+// Package policy 实现清理条件表达式，例如 "ratio >= 2 OR seeding_time >= 72h"。
+//
+// 支持的字段:
+//
+//	ratio          分享率（小数）
+//	seeding_time   累计做种时长
+//	last_activity  距离最后一次上传/下载活动已经过去的时长
+//	num_complete   完整做种者数量（整数）
+//	state          qB 中的任务状态，如 pausedUP、stalledUP，比较时不区分大小写
+//
+// 比较运算符为 >=、>、<=、<、=（或 ==）、!=，state 还支持 IN (a, b, ...) 与 NOT IN (...)。
+// 条件之间可以用 AND、OR、NOT 和括号组合，关键字不区分大小写，AND 的优先级高于 OR。
+// 时长可以写成 30s、90m、72h、7d、2w，不带单位的数字按秒计算。
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Torrent 是表达式求值时使用的任务字段。
+type Torrent struct {
+	Ratio        float64
+	SeedingTime  time.Duration
+	LastActivity time.Duration // 距离最后活动的时长，不是时间戳
+	NumComplete  int64
+	State        string
+}
+
+// Condition 是解析后的清理条件。
+type Condition struct {
+	source string
+	root   node
+}
+
+// Parse 解析条件表达式。
+func Parse(expr string) (*Condition, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("条件表达式为空")
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("条件表达式在 '%s' 附近有多余的内容", p.peek().text)
+	}
+	return &Condition{source: strings.TrimSpace(expr), root: root}, nil
+}
+
+// Match 报告任务是否满足条件。
+func (c *Condition) Match(t Torrent) bool {
+	return c.root.eval(t)
+}
+
+// String 返回原始表达式。
+func (c *Condition) String() string {
+	return c.source
+}
+
+// --- 语法树 ---
+
+type node interface {
+	eval(t Torrent) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(t Torrent) bool { return n.left.eval(t) && n.right.eval(t) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(t Torrent) bool { return n.left.eval(t) || n.right.eval(t) }
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(t Torrent) bool { return !n.inner.eval(t) }
+
+// numberNode 比较数值字段，时长字段统一换算为秒。
+type numberNode struct {
+	field string
+	op    string
+	value float64
+}
+
+func (n numberNode) eval(t Torrent) bool {
+	var v float64
+	switch n.field {
+	case "ratio":
+		v = t.Ratio
+	case "seeding_time":
+		v = t.SeedingTime.Seconds()
+	case "last_activity":
+		v = t.LastActivity.Seconds()
+	case "num_complete":
+		v = float64(t.NumComplete)
+	}
+	switch n.op {
+	case ">=":
+		return v >= n.value
+	case ">":
+		return v > n.value
+	case "<=":
+		return v <= n.value
+	case "<":
+		return v < n.value
+	case "=":
+		return v == n.value
+	default: // "!="
+		return v != n.value
+	}
+}
+
+type stateNode struct {
+	states []string
+	negate bool
+}
+
+func (n stateNode) eval(t Torrent) bool {
+	for _, s := range n.states {
+		if strings.EqualFold(s, t.State) {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+// --- 词法分析 ---
+
+type token struct {
+	text string
+	kind int
+}
+
+const (
+	tokWord = iota
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{"(", tokLParen})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{")", tokRParen})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{",", tokComma})
+			i++
+		case strings.ContainsRune("<>=!", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			switch op {
+			case "==":
+				op = "="
+			case "!":
+				return nil, fmt.Errorf("无法识别的运算符 '!'，请使用 != 或 NOT")
+			}
+			tokens = append(tokens, token{op, tokOp})
+			i += len([]rune(op))
+			if op == "=" && i < len(runes) && runes[i] == '=' {
+				i++
+			}
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("引号没有闭合")
+			}
+			tokens = append(tokens, token{string(runes[i+1 : end]), tokWord})
+			i = end + 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{string(runes[start:i]), tokWord})
+		default:
+			return nil, fmt.Errorf("无法识别的字符 '%c'", r)
+		}
+	}
+	return tokens, nil
+}
+
+// --- 语法分析 ---
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{text: "", kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("缺少右括号")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokWord {
+		return nil, fmt.Errorf("此处应为字段名，实际为 '%s'", fieldTok.text)
+	}
+	field := strings.ToLower(fieldTok.text)
+
+	switch field {
+	case "state":
+		return p.parseState()
+	case "ratio", "seeding_time", "last_activity", "num_complete":
+	default:
+		return nil, fmt.Errorf("未知的字段 '%s'，可用字段: ratio、seeding_time、last_activity、num_complete、state", fieldTok.text)
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokOp {
+		return nil, fmt.Errorf("字段 '%s' 后面应为比较运算符", field)
+	}
+	valueTok := p.next()
+	if valueTok.kind != tokWord {
+		return nil, fmt.Errorf("运算符 '%s' 后面应为数值", opTok.text)
+	}
+	var value float64
+	var err error
+	switch field {
+	case "seeding_time", "last_activity":
+		var d time.Duration
+		d, err = parseDuration(valueTok.text)
+		value = d.Seconds()
+	default:
+		value, err = strconv.ParseFloat(valueTok.text, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("字段 '%s' 的值 '%s' 无效", field, valueTok.text)
+	}
+	return numberNode{field: field, op: opTok.text, value: value}, nil
+}
+
+func (p *parser) parseState() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokOp && (t.text == "=" || t.text == "!="):
+		v := p.next()
+		if v.kind != tokWord {
+			return nil, fmt.Errorf("state %s 后面应为状态名", t.text)
+		}
+		return stateNode{states: []string{v.text}, negate: t.text == "!="}, nil
+	case t.kind == tokWord && strings.EqualFold(t.text, "IN"):
+		return p.parseStateList(false)
+	case t.kind == tokWord && strings.EqualFold(t.text, "NOT") && p.keyword("IN"):
+		return p.parseStateList(true)
+	default:
+		return nil, fmt.Errorf("state 只支持 =、!=、IN 和 NOT IN")
+	}
+}
+
+func (p *parser) parseStateList(negate bool) (node, error) {
+	if p.next().kind != tokLParen {
+		return nil, fmt.Errorf("IN 后面应为括号括起来的状态列表")
+	}
+	var states []string
+	for {
+		v := p.next()
+		if v.kind != tokWord {
+			return nil, fmt.Errorf("状态列表中应为状态名")
+		}
+		states = append(states, v.text)
+		sep := p.next()
+		if sep.kind == tokRParen {
+			break
+		}
+		if sep.kind != tokComma {
+			return nil, fmt.Errorf("状态列表缺少右括号")
+		}
+	}
+	return stateNode{states: states, negate: negate}, nil
+}
+
+// parseDuration 解析 30s、90m、72h、7d、2w 这样的时长，不带单位时按秒计算。
+func parseDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	s = strings.ToLower(s)
+	unit := time.Second
+	if len(s) > 0 {
+		if u, ok := units[s[len(s)-1]]; ok {
+			unit = u
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的时长")
+	}
+	return time.Duration(n * float64(unit)), nil
+}
@@ -0,0 +1,170 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	torrent := Torrent{
+		Ratio:        1.5,
+		SeedingTime:  48 * time.Hour,
+		LastActivity: 90 * time.Minute,
+		NumComplete:  3,
+		State:        "stalledUP",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// 数值比较
+		{"ratio >= 1.5", true},
+		{"ratio > 1.5", false},
+		{"ratio <= 1.5", true},
+		{"ratio < 1.5", false},
+		{"ratio = 1.5", true},
+		{"ratio == 1.5", true},
+		{"ratio != 1.5", false},
+		{"num_complete == 3", true},
+		{"num_complete != 3", false},
+		{"num_complete > 2", true},
+
+		// 时长单位
+		{"seeding_time >= 172800", true},
+		{"seeding_time >= 172801", false},
+		{"seeding_time >= 2880m", true},
+		{"seeding_time >= 48h", true},
+		{"seeding_time > 48h", false},
+		{"seeding_time >= 2d", true},
+		{"seeding_time >= 3d", false},
+		{"seeding_time < 1w", true},
+		{"seeding_time >= 1.5d", true},
+		{"seeding_time >= 48H", true},
+		{"last_activity >= 5400s", true},
+		{"last_activity > 90m", false},
+		{"last_activity < 2h", true},
+
+		// 字符串比较
+		{"state = stalledUP", true},
+		{"state == stalledup", true},
+		{"state = 'stalledUP'", true},
+		{`state = "pausedUP"`, false},
+		{"state != pausedUP", true},
+		{"state != STALLEDUP", false},
+		{"state IN (pausedUP, stalledUP)", true},
+		{"state in (pausedUP)", false},
+		{"state NOT IN (pausedUP, uploading)", true},
+		{"state not in (stalledUP)", false},
+
+		// NOT
+		{"NOT ratio >= 2", true},
+		{"not ratio >= 1", false},
+		{"NOT NOT ratio >= 1", true},
+		{"NOT (ratio >= 2 OR num_complete > 5)", true},
+		{"NOT state IN (stalledUP)", false},
+
+		// AND 的优先级高于 OR
+		{"ratio >= 2 AND num_complete > 5 OR state = stalledUP", true},
+		{"ratio >= 2 AND (num_complete > 5 OR state = stalledUP)", false},
+		{"state = stalledUP OR ratio >= 2 AND num_complete > 5", true},
+		{"(state = stalledUP OR ratio >= 2) AND num_complete > 5", false},
+		{"ratio >= 2 OR seeding_time >= 72h OR last_activity >= 1h", true},
+		{"ratio >= 1 and seeding_time >= 1d and state in (stalledUP)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) 返回错误: %v", tt.expr, err)
+			}
+			if got := c.Match(torrent); got != tt.want {
+				t.Errorf("Parse(%q).Match() = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"", "条件表达式为空"},
+		{"   ", "条件表达式为空"},
+		{"size >= 10", "未知的字段 'size'"},
+		{"ratio 2", "字段 'ratio' 后面应为比较运算符"},
+		{"ratio >=", "运算符 '>=' 后面应为数值"},
+		{"ratio >= abc", "字段 'ratio' 的值 'abc' 无效"},
+		{"seeding_time >= 3y", "字段 'seeding_time' 的值 '3y' 无效"},
+		{"seeding_time >= h", "字段 'seeding_time' 的值 'h' 无效"},
+		{"ratio ! 2", "无法识别的运算符 '!'"},
+		{"ratio >= 2 & num_complete > 1", "无法识别的字符 '&'"},
+		{"state = 'stalledUP", "引号没有闭合"},
+		{"(ratio >= 2", "缺少右括号"},
+		{"ratio >= 2)", "条件表达式在 ')' 附近有多余的内容"},
+		{"ratio >= 2 num_complete > 1", "条件表达式在 'num_complete' 附近有多余的内容"},
+		{"ratio >= 2 AND", "此处应为字段名"},
+		{">= 2", "此处应为字段名，实际为 '>='"},
+		{"state >= stalledUP", "state 只支持 =、!=、IN 和 NOT IN"},
+		{"state =", "state = 后面应为状态名"},
+		{"state IN stalledUP", "IN 后面应为括号括起来的状态列表"},
+		{"state IN (stalledUP", "状态列表缺少右括号"},
+		{"state IN (stalledUP,)", "状态列表中应为状态名"},
+		{"state NOT (stalledUP)", "state 只支持 =、!=、IN 和 NOT IN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil {
+				t.Fatalf("Parse(%q) 没有返回错误", tt.expr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) 的错误为 %q，应包含 %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"0", 0},
+		{"45", 45 * time.Second},
+		{"30s", 30 * time.Second},
+		{"90m", 90 * time.Minute},
+		{"72h", 72 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"0.5h", 30 * time.Minute},
+		{"1D", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if err != nil {
+			t.Errorf("parseDuration(%q) 返回错误: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "d", "-1h", "1x", "1.2.3h"} {
+		if _, err := parseDuration(in); err == nil {
+			t.Errorf("parseDuration(%q) 没有返回错误", in)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	c, err := Parse("  ratio >= 2 OR seeding_time >= 72h  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.String(), "ratio >= 2 OR seeding_time >= 72h"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+// Package proc 负责启动外部命令（BaiduPCS-Go、rclone 等）。
+// 命令在独立的进程组中运行，context 被取消时结束整个进程组，不会留下孤儿进程。
+package proc
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// waitDelay 是结束进程组后等待输出管道关闭的最长时间。
+const waitDelay = 5 * time.Second
+
+// CommandContext 与 exec.CommandContext 相同，但 ctx 被取消或超时时会结束命令启动的所有子进程。
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package proc
+
+import "os/exec"
+
+// setProcessGroup 在当前平台上不做任何处理。
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup 在当前平台上只能结束命令本身。
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
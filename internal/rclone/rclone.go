@@ -0,0 +1,276 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/logger"
+	"qbuploader/internal/proc"
+	"qbuploader/internal/storage"
+)
+
+// rclone 的退出码，参见 https://rclone.org/docs/#exit-code
+const (
+	exitDirNotFound  = 3
+	exitFileNotFound = 4
+)
+
+// Uploader 通过调用 rclone 命令行实现 storage.Backend 接口。
+type Uploader struct {
+	executablePath string
+	remote         string
+	extraArgs      []string
+	verifyHash     bool
+}
+
+var (
+	_ storage.Backend  = (*Uploader)(nil)
+	_ storage.Verifier = (*Uploader)(nil)
+)
+
+// NewUploader 创建一个新的 rclone Uploader 实例。
+func NewUploader() *Uploader {
+	return &Uploader{
+		executablePath: config.Cfg.Rclone.Path,
+		remote:         config.Cfg.Rclone.Remote,
+		extraArgs:      config.Cfg.Rclone.ExtraArgs,
+		verifyHash:     config.Cfg.Rclone.VerifyHash,
+	}
+}
+
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "rclone"
+}
+
+// lsjsonItem 对应 `rclone lsjson` 输出中的一项。
+type lsjsonItem struct {
+	Path    string            `json:"Path"`
+	Name    string            `json:"Name"`
+	Size    int64             `json:"Size"`
+	ModTime time.Time         `json:"ModTime"`
+	IsDir   bool              `json:"IsDir"`
+	Hashes  map[string]string `json:"Hashes"`
+}
+
+// Upload 使用 `rclone copy` 上传文件或目录。
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
+	log := logger.Log
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("无法读取本地路径: %w", err)
+	}
+	// rclone copy 复制的是源目录的“内容”，因此目录需要显式带上自身名称。
+	dest := remoteDir
+	if info.IsDir() {
+		dest = storage.JoinRemote(remoteDir, filepath.Base(localPath))
+	}
+
+	args := []string{"copy", localPath, u.target(dest)}
+	args = append(args, u.extraArgs...)
+
+	log.Infof("  -> 正在上传: %s -> %s", localPath, u.target(dest))
+	stdout, stderr, err := u.run(ctx, 24*time.Hour, args...) // 24小时超时
+	if err != nil {
+		log.Errorf("rclone 上传失败。输出: %s, 错误: %s", stdout, stderr)
+		return fmt.Errorf("执行 rclone copy 命令失败: %w", err)
+	}
+
+	log.Debugf("rclone 上传成功。输出: %s", stdout)
+	return nil
+}
+
+// Exists 判断远程路径是否存在。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验远程文件: %s", u.target(remotePath))
+	_, err := u.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stat 使用 `rclone lsjson --stat` 获取远程路径信息。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	stdout, stderr, err := u.run(ctx, 5*time.Minute, "lsjson", "--stat", u.target(remotePath))
+	if err != nil {
+		return nil, u.wrapError("lsjson", remotePath, stderr, err)
+	}
+	var item lsjsonItem
+	if err := json.Unmarshal([]byte(stdout), &item); err != nil {
+		return nil, fmt.Errorf("解析 rclone lsjson 输出失败: %w", err)
+	}
+	return &storage.FileInfo{
+		Path:    remotePath,
+		Name:    path.Base(remotePath),
+		Size:    item.Size,
+		IsDir:   item.IsDir,
+		ModTime: item.ModTime,
+	}, nil
+}
+
+// Delete 删除远程文件或目录。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	info, err := u.Stat(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	command := "deletefile"
+	if info.IsDir {
+		command = "purge"
+	}
+	if _, stderr, err := u.run(ctx, 30*time.Minute, command, u.target(remotePath)); err != nil {
+		return u.wrapError(command, remotePath, stderr, err)
+	}
+	return nil
+}
+
+// List 列出远程目录下的直接子项。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	items, err := u.lsjson(ctx, remoteDir, false)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]storage.FileInfo, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, storage.FileInfo{
+			Path:    storage.JoinRemote(remoteDir, item.Path),
+			Name:    item.Name,
+			Size:    item.Size,
+			IsDir:   item.IsDir,
+			ModTime: item.ModTime,
+		})
+	}
+	return entries, nil
+}
+
+// Verify 使用一次 `rclone lsjson -R` 的结果逐个比对文件的大小，
+// 开启 Verify_Hash 时还会附带 --hash 比对远程提供的 MD5/SHA-1。
+func (u *Uploader) Verify(ctx context.Context, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	items, err := u.lsjson(ctx, remoteDir, true)
+	if err != nil {
+		return nil, err
+	}
+	remoteFiles := make(map[string]lsjsonItem, len(items))
+	for _, item := range items {
+		if !item.IsDir {
+			remoteFiles[item.Path] = item
+		}
+	}
+
+	results := make([]error, len(files))
+	for i, lf := range files {
+		item, ok := remoteFiles[lf.RelPath]
+		if !ok {
+			results[i] = fmt.Errorf("远程缺少文件 '%s': %w", lf.RelPath, storage.ErrNotFound)
+			continue
+		}
+		results[i] = u.compare(lf, item)
+	}
+	logger.Log.Debugf("  -> rclone 校验完成，共比对 %d 个文件。", len(files))
+	return results, nil
+}
+
+// compare 比对一个本地文件与 lsjson 列出的远程文件。
+func (u *Uploader) compare(lf storage.LocalFile, item lsjsonItem) error {
+	if item.Size != lf.Size {
+		return fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 远程 %d): %w", lf.RelPath, lf.Size, item.Size, storage.ErrMismatch)
+	}
+	if !u.verifyHash {
+		return nil
+	}
+	algo, remoteHash := pickHash(item.Hashes)
+	if algo == "" {
+		logger.Log.Debugf("  -> 远程未提供 '%s' 的可用哈希，仅比对大小。", lf.RelPath)
+		return nil
+	}
+	localHash, err := storage.HashFile(lf.AbsPath, algo)
+	if err != nil {
+		return fmt.Errorf("计算本地文件 '%s' 的 %s 失败: %w", lf.RelPath, algo, err)
+	}
+	if !strings.EqualFold(localHash, remoteHash) {
+		return fmt.Errorf("文件 '%s' 的 %s 不一致: %w", lf.RelPath, algo, storage.ErrMismatch)
+	}
+	return nil
+}
+
+// lsjson 执行 `rclone lsjson`，recursive 为 true 时递归列出所有文件。
+// 只有开启 Verify_Hash 时才附带哈希：不少远程需要读取整个文件才能算出哈希，代价很高。
+func (u *Uploader) lsjson(ctx context.Context, remoteDir string, recursive bool) ([]lsjsonItem, error) {
+	args := []string{"lsjson"}
+	if recursive {
+		args = append(args, "-R", "--files-only")
+		if u.verifyHash {
+			args = append(args, "--hash")
+		}
+	}
+	args = append(args, u.target(remoteDir))
+
+	stdout, stderr, err := u.run(ctx, 30*time.Minute, args...)
+	if err != nil {
+		return nil, u.wrapError("lsjson", remoteDir, stderr, err)
+	}
+	var items []lsjsonItem
+	if err := json.Unmarshal([]byte(stdout), &items); err != nil {
+		return nil, fmt.Errorf("解析 rclone lsjson 输出失败: %w", err)
+	}
+	return items, nil
+}
+
+// target 将远程路径拼接为 rclone 能识别的 "remote:path" 形式。
+func (u *Uploader) target(remotePath string) string {
+	return u.remote + remotePath
+}
+
+// wrapError 将 rclone 的“找不到”退出码转换为 storage.ErrNotFound。
+func (u *Uploader) wrapError(command, remotePath, stderr string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case exitDirNotFound, exitFileNotFound:
+			return fmt.Errorf("%s: %w", u.target(remotePath), storage.ErrNotFound)
+		}
+	}
+	return fmt.Errorf("执行 rclone %s 命令失败: %w (%s)", command, err, strings.TrimSpace(stderr))
+}
+
+// run 以给定的超时时间执行一条 rclone 命令，返回标准输出和标准错误。
+func (u *Uploader) run(ctx context.Context, timeout time.Duration, args ...string) (string, string, error) {
+	logger.Log.Debugf("  -> 执行命令: %s %v", u.executablePath, args)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := proc.CommandContext(ctx, u.executablePath, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// pickHash 从远程提供的哈希中挑选一个本地也能计算的算法。
+func pickHash(hashes map[string]string) (string, string) {
+	for _, algo := range []string{"md5", "sha1"} {
+		if h := hashes[algo]; h != "" {
+			return algo, h
+		}
+	}
+	return "", ""
+}
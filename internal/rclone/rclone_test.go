@@ -0,0 +1,301 @@
+package rclone
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+)
+
+// fakeRclone 是一个代替 rclone 的脚本：记录收到的参数，并按预先设置的内容输出和退出。
+type fakeRclone struct {
+	bin string
+}
+
+const fakeScript = `#!/bin/sh
+printf '%s\n' "$@" > "$0.args"
+[ -f "$0.stdout" ] && cat "$0.stdout"
+[ -f "$0.stderr" ] && cat "$0.stderr" >&2
+exit $(cat "$0.code")
+`
+
+func newFakeRclone(t *testing.T, verifyHash bool) (*Uploader, *fakeRclone) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("需要 /bin/sh")
+	}
+	f := &fakeRclone{bin: filepath.Join(t.TempDir(), "rclone")}
+	writeFile(t, f.bin, []byte(fakeScript))
+	if err := os.Chmod(f.bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f.respond(t, "", "", 0)
+
+	config.Cfg = new(config.Config)
+	config.Cfg.Rclone.Path = f.bin
+	config.Cfg.Rclone.Remote = "remote:"
+	config.Cfg.Rclone.ExtraArgs = []string{"--transfers", "8"}
+	config.Cfg.Rclone.VerifyHash = verifyHash
+	return NewUploader(), f
+}
+
+// respond 设置下一次调用的标准输出、标准错误和退出码。
+func (f *fakeRclone) respond(t *testing.T, stdout, stderr string, code int) {
+	t.Helper()
+	writeFile(t, f.bin+".stdout", []byte(stdout))
+	writeFile(t, f.bin+".stderr", []byte(stderr))
+	writeFile(t, f.bin+".code", []byte(strconv.Itoa(code)))
+}
+
+// args 返回最近一次调用的参数。
+func (f *fakeRclone) args(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile(f.bin + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// listing 把 items 编码为 `rclone lsjson` 的输出。
+func listing(t *testing.T, items ...lsjsonItem) string {
+	t.Helper()
+	data, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify 校验 localPath 中的所有文件，返回各文件不一致的原因（合并为一个错误）。
+func verify(u *Uploader, localPath, remoteDir string) error {
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	results, err := u.Verify(context.Background(), files, remoteDir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(results...)
+}
+
+func TestUploadTarget(t *testing.T) {
+	u, f := newFakeRclone(t, false)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode"))
+
+	// rclone copy 复制的是目录的内容，目标需要带上目录名
+	if err := u.Upload(context.Background(), dir, "backups/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := strings.Join(f.args(t), " "), "copy "+dir+" remote:backups/tv/Show --transfers 8"; got != want {
+		t.Errorf("上传目录时的参数为 %q，应为 %q", got, want)
+	}
+
+	file := filepath.Join(dir, "ep1.mkv")
+	if err := u.Upload(context.Background(), file, "backups/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := strings.Join(f.args(t), " "), "copy "+file+" remote:backups/tv --transfers 8"; got != want {
+		t.Errorf("上传文件时的参数为 %q，应为 %q", got, want)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		code     int
+		stderr   string
+		notFound bool
+	}{
+		{exitDirNotFound, "directory not found", true},
+		{exitFileNotFound, "object not found", true},
+		{1, "Failed to create file system: didn't find section in config file", false},
+		{7, "Fatal error", false},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.code), func(t *testing.T) {
+			u, f := newFakeRclone(t, false)
+			f.respond(t, "", tt.stderr, tt.code)
+
+			_, err := u.Stat(context.Background(), "tv/Show")
+			if got := errors.Is(err, storage.ErrNotFound); got != tt.notFound {
+				t.Fatalf("退出码 %d: Stat 的错误为 %v，ErrNotFound = %v，应为 %v", tt.code, err, got, tt.notFound)
+			}
+			if !tt.notFound && !strings.Contains(err.Error(), tt.stderr) {
+				t.Errorf("错误 %q 中应包含 rclone 的输出 %q", err, tt.stderr)
+			}
+
+			exists, err := u.Exists(context.Background(), "tv/Show")
+			if tt.notFound && (exists || err != nil) {
+				t.Errorf("Exists = %v, %v，应为 false, nil", exists, err)
+			}
+			if !tt.notFound && err == nil {
+				t.Errorf("退出码 %d 时 Exists 应返回错误", tt.code)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Show")
+	episode := []byte("episode one")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), episode)
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	md5Sum := md5.Sum(episode)
+	sha1Sum := sha1.Sum(episode)
+	nfo := lsjsonItem{Path: "Show/Extras/info.nfo", Name: "info.nfo", Size: 17}
+	ep := func(size int64, hashes map[string]string) lsjsonItem {
+		return lsjsonItem{Path: "Show/ep1.mkv", Name: "ep1.mkv", Size: size, Hashes: hashes}
+	}
+
+	tests := []struct {
+		name       string
+		verifyHash bool
+		items      []lsjsonItem
+		want       error
+	}{
+		{"一致", true, []lsjsonItem{nfo, ep(11, map[string]string{"md5": hex.EncodeToString(md5Sum[:])})}, nil},
+		{"只有 SHA-1", true, []lsjsonItem{nfo, ep(11, map[string]string{"sha1": hex.EncodeToString(sha1Sum[:])})}, nil},
+		{"MD5 优先于 SHA-1", true, []lsjsonItem{nfo, ep(11, map[string]string{"md5": strings.Repeat("0", 32), "sha1": hex.EncodeToString(sha1Sum[:])})}, storage.ErrMismatch},
+		{"MD5 不一致", true, []lsjsonItem{nfo, ep(11, map[string]string{"md5": strings.Repeat("0", 32)})}, storage.ErrMismatch},
+		{"不校验哈希", false, []lsjsonItem{nfo, ep(11, map[string]string{"md5": strings.Repeat("0", 32)})}, nil},
+		{"远程不提供哈希", true, []lsjsonItem{nfo, ep(11, nil)}, nil},
+		{"大小不一致", false, []lsjsonItem{nfo, ep(10, nil)}, storage.ErrMismatch},
+		{"缺少文件", true, []lsjsonItem{nfo}, storage.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, f := newFakeRclone(t, tt.verifyHash)
+			f.respond(t, listing(t, tt.items...), "", 0)
+
+			err := verify(u, dir, "tv")
+			if tt.want == nil && err != nil {
+				t.Errorf("Verify 返回错误: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Verify 的错误为 %v，应为 %v", err, tt.want)
+			}
+			if args := f.args(t); args[len(args)-1] != "remote:tv" {
+				t.Errorf("lsjson 的参数为 %q", args)
+			}
+		})
+	}
+}
+
+func TestVerifyDirNotFound(t *testing.T) {
+	u, f := newFakeRclone(t, false)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode"))
+
+	f.respond(t, "", "directory not found", exitDirNotFound)
+	if err := verify(u, dir, "tv"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("远程目录不存在时 Verify 的错误为 %v，应为 ErrNotFound", err)
+	}
+	f.respond(t, "not json", "", 0)
+	if err := verify(u, dir, "tv"); err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("lsjson 输出无法解析时 Verify 的错误为 %v，应为无法校验", err)
+	}
+}
+
+// TestLocalRemote 使用真实的 rclone 和 ":local:" 远程完成一次上传和校验，没有安装 rclone 时跳过。
+func TestLocalRemote(t *testing.T) {
+	bin, err := exec.LookPath("rclone")
+	if err != nil {
+		t.Skip("没有安装 rclone，跳过测试")
+	}
+	config.Cfg = new(config.Config)
+	config.Cfg.Rclone.Path = bin
+	config.Cfg.Rclone.Remote = ":local:"
+	config.Cfg.Rclone.VerifyHash = true
+	u := NewUploader()
+	remote := filepath.ToSlash(t.TempDir())
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+
+	if err := u.Upload(context.Background(), dir, remote); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := verify(u, dir, remote); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// 大小相同、内容不同，只能通过哈希发现
+	writeFile(t, filepath.Join(remote, "Show", "ep1.mkv"), []byte("episode two"))
+	if err := verify(u, dir, remote); !errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("Verify 的错误为 %v，应为 ErrMismatch", err)
+	}
+	if _, err := u.Stat(context.Background(), remote+"/Show/missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Stat(不存在) 的错误为 %v，应为 ErrNotFound", err)
+	}
+}
+
+func TestVerifyPerFile(t *testing.T) {
+	u, f := newFakeRclone(t, false)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), []byte("episode two"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	files, err := storage.WalkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 一次 lsjson 的结果逐个比对，每个文件各自给出结论
+	f.respond(t, listing(t,
+		lsjsonItem{Path: "Show/Extras/info.nfo", Name: "info.nfo", Size: 17},
+		lsjsonItem{Path: "Show/ep1.mkv", Name: "ep1.mkv", Size: 7},
+	), "", 0)
+	results, err := u.Verify(context.Background(), files, "tv")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := map[string]error{"Show/Extras/info.nfo": nil, "Show/ep1.mkv": storage.ErrMismatch, "Show/ep2.mkv": storage.ErrNotFound}
+	for i, lf := range files {
+		if w := want[lf.RelPath]; (w == nil) != (results[i] == nil) || !errors.Is(results[i], w) {
+			t.Errorf("%s: 结果为 %v，应为 %v", lf.RelPath, results[i], w)
+		}
+	}
+
+	// lsjson 本身失败时无法校验任何文件，返回整体的错误
+	f.respond(t, "", "Fatal error", 1)
+	if results, err := u.Verify(context.Background(), files, "tv"); err == nil {
+		t.Errorf("lsjson 失败时 Verify 应返回错误，实际结果为 %v", results)
+	}
+}
+
+// TestVerifyHashFlag 检查只有开启 Verify_Hash 时才让 lsjson 计算哈希。
+func TestVerifyHashFlag(t *testing.T) {
+	for _, verifyHash := range []bool{false, true} {
+		u, f := newFakeRclone(t, verifyHash)
+		f.respond(t, "[]", "", 0)
+		if _, err := u.Verify(context.Background(), nil, "tv"); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if got := slices.Contains(f.args(t), "--hash"); got != verifyHash {
+			t.Errorf("Verify_Hash = %v 时 lsjson 的参数为 %q", verifyHash, f.args(t))
+		}
+	}
+}
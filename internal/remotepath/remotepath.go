@@ -0,0 +1,105 @@
+// Package remotepath 负责把上传目录模板（如 "{category}/{year}/{name}"）渲染成远程路径。
+package remotepath
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Variables 列出模板中可以使用的变量及其含义。
+var Variables = map[string]string{
+	"name":            "任务名称",
+	"category":        "qB 分类",
+	"tag":             "qB 第一个标签",
+	"tracker":         "Tracker 域名",
+	"year":            "完成时间的年份",
+	"month":           "完成时间的月份（两位）",
+	"day":             "完成时间的日期（两位）",
+	"info_hash":       "完整的 InfoHash",
+	"info_hash_short": "InfoHash 的前 8 位",
+}
+
+// localVariables 是不需要查询 qB 就能得到的变量。
+var localVariables = map[string]bool{"name": true, "info_hash": true, "info_hash_short": true}
+
+// Placeholder 是变量取值为空时使用的占位名称。
+const Placeholder = "other"
+
+var variablePattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Validate 检查模板中的变量是否都受支持、花括号是否配对。
+func Validate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("模板不能为空")
+	}
+	for _, m := range variablePattern.FindAllStringSubmatch(template, -1) {
+		if _, ok := Variables[m[1]]; !ok {
+			return fmt.Errorf("未知的变量 {%s}", m[1])
+		}
+	}
+	if rest := variablePattern.ReplaceAllString(template, ""); strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("花括号不配对")
+	}
+	return nil
+}
+
+// NeedsTorrentInfo 报告模板是否用到了需要从 qB 读取的变量。
+func NeedsTorrentInfo(template string) bool {
+	for _, m := range variablePattern.FindAllStringSubmatch(template, -1) {
+		if !localVariables[m[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// Render 用 vars 替换模板中的变量，返回以 "/" 分隔的相对路径。
+// 每个变量的值和最终的每一级目录名都会经过 Sanitize 处理，变量值中的 "/" 不会产生新的目录层级。
+//
+// Render 只负责上传目录本身。任务内部的文件和文件夹（storage.LocalFile.RelPath）按原名上传和校验，
+// 不经过 Sanitize：远程副本需要与本地布局逐个对应，各后端的 Upload 也总是沿用本地的文件名。
+// 原名无法在百度网盘中使用时由 CheckName 在上传前拒绝。
+func Render(template string, vars map[string]string) string {
+	rendered := variablePattern.ReplaceAllStringFunc(template, func(m string) string {
+		value := Sanitize(vars[m[1:len(m)-1]])
+		if value == "" {
+			return Placeholder
+		}
+		return value
+	})
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(rendered, "\\", "/"), "/") {
+		if part = Sanitize(part); part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// invalidChars 是百度网盘不允许出现在文件名中的字符，其他后端也统一替换，保证各目的地的路径一致。
+const invalidChars = `\/:*?"<>|`
+
+// CheckName 检查名称中是否含有百度网盘不允许的字符或控制字符，有则返回说明原因的错误。
+func CheckName(name string) error {
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("名称 %q 含有控制字符", name)
+		}
+		if strings.ContainsRune(invalidChars, r) {
+			return fmt.Errorf("名称 %q 含有不允许的字符 '%c'", name, r)
+		}
+	}
+	return nil
+}
+
+// Sanitize 将名称中不允许的字符和控制字符替换为 "_"，并去掉首尾的空格和句点。
+func Sanitize(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(invalidChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, " .")
+}
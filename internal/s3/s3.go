@@ -0,0 +1,379 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/logger"
+	"qbuploader/internal/storage"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// minPartSize 是 S3 规定的分片最小值（最后一片除外）。
+const minPartSize = 5 << 20
+
+// Uploader 将内容上传到 S3 兼容的对象存储（MinIO、Cloudflare R2、Backblaze B2 等），
+// 实现了 storage.Backend 接口。远程路径去掉开头的 "/" 后即为对象键。
+type Uploader struct {
+	client   *minio.Client
+	core     minio.Core
+	bucket   string
+	partSize int64
+}
+
+var (
+	_ storage.Backend  = (*Uploader)(nil)
+	_ storage.Verifier = (*Uploader)(nil)
+)
+
+// NewUploader 根据 [S3] 配置创建一个新的 S3 Uploader 实例。
+func NewUploader() (*Uploader, error) {
+	cfg := config.Cfg.S3
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("[S3] 中的 Endpoint 和 Bucket 不能为空")
+	}
+	opts := &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	if cfg.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("创建 S3 客户端失败: %w", err)
+	}
+	partSize := int64(cfg.PartSizeMB) << 20
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	return &Uploader{
+		client:   client,
+		core:     minio.Core{Client: client},
+		bucket:   cfg.Bucket,
+		partSize: partSize,
+	}, nil
+}
+
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "s3"
+}
+
+// Upload 逐个上传文件到 remoteDir 对应的前缀下。
+// 大于分片大小的文件使用分片上传，中断后重新执行会复用已上传的分片；
+// 远程已存在且 ETag 一致的文件会被跳过。
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
+	log := logger.Log
+
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	log.Infof("  -> 正在上传: %s -> s3://%s/%s", localPath, u.bucket, objectKey(remoteDir))
+
+	for _, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := objectKey(storage.JoinRemote(remoteDir, lf.RelPath))
+		if obj, err := u.client.StatObject(ctx, u.bucket, key, minio.StatObjectOptions{}); err == nil && obj.Size == lf.Size {
+			if err := u.compareETag(lf, obj.ETag); err == nil {
+				log.Debugf("  -> 对象已存在且一致，跳过: %s", key)
+				continue
+			}
+		}
+		if lf.Size <= u.partSize {
+			_, err = u.client.FPutObject(ctx, u.bucket, key, lf.AbsPath, minio.PutObjectOptions{PartSize: uint64(u.partSize)})
+		} else {
+			err = u.multipartUpload(ctx, lf, key)
+		}
+		if err != nil {
+			return fmt.Errorf("上传 '%s' 失败: %w", lf.RelPath, err)
+		}
+		log.Debugf("  -> 已上传: %s", key)
+	}
+	return nil
+}
+
+// Exists 判断远程路径是否存在，既可以是单个对象，也可以是一个非空前缀。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验对象存储: s3://%s/%s", u.bucket, objectKey(remotePath))
+	_, err := u.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stat 获取对象信息。对象存储没有真正的目录，存在该前缀下的对象时视为目录。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	key := objectKey(remotePath)
+	obj, err := u.client.StatObject(ctx, u.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return &storage.FileInfo{Path: remotePath, Name: path.Base(key), Size: obj.Size, ModTime: obj.LastModified}, nil
+	}
+	if minio.ToErrorResponse(err).Code != minio.NoSuchKey {
+		return nil, fmt.Errorf("获取对象 '%s' 信息失败: %w", key, err)
+	}
+
+	for obj := range u.client.ListObjects(ctx, u.bucket, minio.ListObjectsOptions{Prefix: key + "/", MaxKeys: 1}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("列出前缀 '%s' 失败: %w", key, obj.Err)
+		}
+		return &storage.FileInfo{Path: remotePath, Name: path.Base(key), Size: -1, IsDir: true}, nil
+	}
+	return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+}
+
+// Delete 删除对象，或删除该前缀下的所有对象。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	key := objectKey(remotePath)
+	if err := u.client.RemoveObject(ctx, u.bucket, key, minio.RemoveObjectOptions{}); err != nil && minio.ToErrorResponse(err).Code != minio.NoSuchKey {
+		return fmt.Errorf("删除对象 '%s' 失败: %w", key, err)
+	}
+	objects := u.client.ListObjects(ctx, u.bucket, minio.ListObjectsOptions{Prefix: key + "/", Recursive: true})
+	for res := range u.client.RemoveObjects(ctx, u.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if res.Err != nil {
+			return fmt.Errorf("删除对象 '%s' 失败: %w", res.ObjectName, res.Err)
+		}
+	}
+	return nil
+}
+
+// List 列出前缀下的直接子项，公共前缀视为目录。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	prefix := objectKey(remoteDir) + "/"
+	var entries []storage.FileInfo
+	for obj := range u.client.ListObjects(ctx, u.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("列出前缀 '%s' 失败: %w", prefix, obj.Err)
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/")
+		entry := storage.FileInfo{
+			Path:    storage.JoinRemote(remoteDir, name),
+			Name:    name,
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			entry.IsDir = true
+			entry.Size = -1
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: %w", remoteDir, storage.ErrNotFound)
+	}
+	return entries, nil
+}
+
+// Verify 一次列出 remoteDir 下的所有对象，逐个比对文件的大小和 ETag。
+// 服务端加密等原因导致 ETag 不是 MD5 形式时，只比对大小。
+func (u *Uploader) Verify(ctx context.Context, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	prefix := objectKey(remoteDir) + "/"
+	remote := make(map[string]minio.ObjectInfo)
+	for obj := range u.client.ListObjects(ctx, u.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("列出前缀 '%s' 失败: %w", prefix, obj.Err)
+		}
+		remote[strings.TrimPrefix(obj.Key, prefix)] = obj
+	}
+
+	results := make([]error, len(files))
+	for i, lf := range files {
+		obj, ok := remote[lf.RelPath]
+		switch {
+		case !ok:
+			results[i] = fmt.Errorf("对象存储缺少文件 '%s': %w", lf.RelPath, storage.ErrNotFound)
+		case obj.Size != lf.Size:
+			results[i] = fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 远程 %d): %w", lf.RelPath, lf.Size, obj.Size, storage.ErrMismatch)
+		default:
+			results[i] = u.compareETag(lf, obj.ETag)
+		}
+	}
+	logger.Log.Debugf("  -> S3 校验完成，共比对 %d 个文件。", len(files))
+	return results, nil
+}
+
+// compareETag 按照 ETag 的格式计算本地文件的期望值并比较。
+// 单次上传的 ETag 为文件 MD5；分片上传的 ETag 为各分片 MD5 拼接后再取 MD5，并附加 "-分片数"。
+func (u *Uploader) compareETag(lf storage.LocalFile, etag string) error {
+	etag = strings.Trim(etag, `"`)
+	sum, partsText, multipart := strings.Cut(etag, "-")
+	if len(sum) != 32 {
+		logger.Log.Debugf("  -> '%s' 的 ETag 不是 MD5 格式，仅比对大小。", lf.RelPath)
+		return nil
+	}
+
+	var expected string
+	var err error
+	if !multipart {
+		expected, err = storage.HashFile(lf.AbsPath, "md5")
+	} else {
+		parts, convErr := strconv.Atoi(partsText)
+		if convErr != nil || partCount(lf.Size, u.partSize) != parts {
+			logger.Log.Debugf("  -> '%s' 不是以当前分片大小上传的，仅比对大小。", lf.RelPath)
+			return nil
+		}
+		expected, err = multipartETag(lf.AbsPath, u.partSize)
+	}
+	if err != nil {
+		return fmt.Errorf("计算本地文件 '%s' 的 ETag 失败: %w", lf.RelPath, err)
+	}
+	if !strings.EqualFold(expected, sum) {
+		return fmt.Errorf("文件 '%s' 的 ETag 不一致: %w", lf.RelPath, storage.ErrMismatch)
+	}
+	return nil
+}
+
+// multipartUpload 以可续传的方式分片上传单个文件。
+// 如果该对象已有未完成的分片上传，会复用其中大小和 MD5 都正确的分片，只补传缺失的部分。
+func (u *Uploader) multipartUpload(ctx context.Context, lf storage.LocalFile, key string) error {
+	log := logger.Log
+
+	f, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	uploadID, uploaded, err := u.findIncompleteUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		if uploadID, err = u.core.NewMultipartUpload(ctx, u.bucket, key, minio.PutObjectOptions{}); err != nil {
+			return fmt.Errorf("创建分片上传失败: %w", err)
+		}
+	} else {
+		log.Infof("  -> 发现未完成的分片上传，已上传 %d 个分片，继续上传: %s", len(uploaded), key)
+	}
+
+	total := partCount(lf.Size, u.partSize)
+	completed := make([]minio.CompletePart, 0, total)
+	for n := 1; n <= total; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		offset := int64(n-1) * u.partSize
+		size := min(u.partSize, lf.Size-offset)
+		section := io.NewSectionReader(f, offset, size)
+
+		h := md5.New()
+		if _, err := io.Copy(h, section); err != nil {
+			return err
+		}
+		sum := h.Sum(nil)
+		if part, ok := uploaded[n]; ok && part.Size == size && strings.EqualFold(strings.Trim(part.ETag, `"`), hex.EncodeToString(sum)) {
+			completed = append(completed, minio.CompletePart{PartNumber: n, ETag: part.ETag})
+			continue
+		}
+
+		opts := minio.PutObjectPartOptions{Md5Base64: base64.StdEncoding.EncodeToString(sum)}
+		part, err := u.core.PutObjectPart(ctx, u.bucket, key, uploadID, n, io.NewSectionReader(f, offset, size), size, opts)
+		if err != nil {
+			return fmt.Errorf("上传第 %d/%d 个分片失败: %w", n, total, err)
+		}
+		log.Debugf("  -> 分片 %d/%d 上传完成: %s", n, total, key)
+		completed = append(completed, minio.CompletePart{PartNumber: n, ETag: part.ETag})
+	}
+
+	if _, err := u.core.CompleteMultipartUpload(ctx, u.bucket, key, uploadID, completed, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("完成分片上传失败: %w", err)
+	}
+	return nil
+}
+
+// findIncompleteUpload 查找对象最近一次未完成的分片上传，并返回其已上传的分片。
+func (u *Uploader) findIncompleteUpload(ctx context.Context, key string) (string, map[int]minio.ObjectPart, error) {
+	result, err := u.core.ListMultipartUploads(ctx, u.bucket, key, "", "", "", 1000)
+	if minio.ToErrorResponse(err).Code == minio.NoSuchUpload {
+		// 部分兼容实现在没有未完成的上传时会返回 NoSuchUpload
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("查询未完成的分片上传失败: %w", err)
+	}
+	var latest *minio.ObjectMultipartInfo
+	for i, upload := range result.Uploads {
+		if upload.Key == key && (latest == nil || upload.Initiated.After(latest.Initiated)) {
+			latest = &result.Uploads[i]
+		}
+	}
+	if latest == nil {
+		return "", nil, nil
+	}
+
+	parts := make(map[int]minio.ObjectPart)
+	marker := 0
+	for {
+		res, err := u.core.ListObjectParts(ctx, u.bucket, key, latest.UploadID, marker, 1000)
+		if err != nil {
+			return "", nil, fmt.Errorf("查询已上传的分片失败: %w", err)
+		}
+		for _, p := range res.ObjectParts {
+			parts[p.PartNumber] = p
+		}
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextPartNumberMarker
+	}
+	return latest.UploadID, parts, nil
+}
+
+// objectKey 将远程路径转换为对象键（去掉开头的 "/"）。
+func objectKey(remotePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+remotePath), "/")
+}
+
+// partCount 计算给定分片大小下文件的分片数量。
+func partCount(size, partSize int64) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + partSize - 1) / partSize)
+}
+
+// multipartETag 计算文件以 partSize 分片上传后的 ETag（不含 "-分片数" 后缀）。
+func multipartETag(filePath string, partSize int64) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var sums []byte
+	parts := partCount(info.Size(), partSize)
+	for n := 0; n < parts; n++ {
+		offset := int64(n) * partSize
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, min(partSize, info.Size()-offset))); err != nil {
+			return "", err
+		}
+		sums = h.Sum(sums)
+	}
+	total := md5.Sum(sums)
+	return hex.EncodeToString(total[:]), nil
+}
@@ -0,0 +1,428 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const testBucket = "backups"
+
+// testServer 是基于 gofakes3 的内存对象存储，记录收到的 PUT 请求和列出对象的次数。
+type testServer struct {
+	mu    sync.Mutex
+	puts  []string // 对象键，分片上传时附加 "#分片编号"
+	lists int
+}
+
+// newTestServer 启动测试服务，并以 [S3] Part_Size_MB = partSizeMB 创建 Uploader。
+func newTestServer(t *testing.T, bucket string, partSizeMB int) (*Uploader, *testServer) {
+	t.Helper()
+	backend := s3mem.New()
+	if err := backend.CreateBucket(testBucket); err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{}
+	faker := gofakes3.New(backend).Server()
+	// 通过 HTTP 访问时 minio-go 对分片使用 aws-chunked 流式签名，gofakes3 只在普通上传中解码这种格式，
+	// 因此测试服务使用 HTTPS
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Has("list-type") {
+			s.mu.Lock()
+			s.lists++
+			s.mu.Unlock()
+		}
+		if r.Method == http.MethodPut {
+			key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+			if n := r.URL.Query().Get("partNumber"); n != "" {
+				key += "#" + n
+			}
+			s.mu.Lock()
+			s.puts = append(s.puts, key)
+			s.mu.Unlock()
+		}
+		faker.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	config.Cfg = new(config.Config)
+	config.Cfg.S3.Endpoint = strings.TrimPrefix(srv.URL, "https://")
+	config.Cfg.S3.Region = "us-east-1"
+	config.Cfg.S3.Bucket = bucket
+	config.Cfg.S3.AccessKey = "key"
+	config.Cfg.S3.SecretKey = "secret"
+	config.Cfg.S3.UseSSL = true
+	config.Cfg.S3.PathStyle = true
+	config.Cfg.S3.PartSizeMB = partSizeMB
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 换成信任测试证书的客户端，其余选项与 NewUploader 相同
+	u.client, err = minio.New(config.Cfg.S3.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Secure:       true,
+		Region:       config.Cfg.S3.Region,
+		BucketLookup: minio.BucketLookupPath,
+		Transport:    srv.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.core = minio.Core{Client: u.client}
+	return u, s
+}
+
+// takePuts 返回并清空目前记录的 PUT 请求。
+func (s *testServer) takePuts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	puts := s.puts
+	s.puts = nil
+	return puts
+}
+
+// takeLists 返回并清零目前列出对象的次数。
+func (s *testServer) takeLists() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lists := s.lists
+	s.lists = 0
+	return lists
+}
+
+// object 读取对象的完整内容。
+func object(t *testing.T, u *Uploader, key string) []byte {
+	t.Helper()
+	obj, err := u.client.GetObject(context.Background(), testBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatalf("读取对象 '%s' 失败: %v", key, err)
+	}
+	return data
+}
+
+func putObject(t *testing.T, u *Uploader, key string, data []byte) {
+	t.Helper()
+	if _, err := u.client.PutObject(context.Background(), testBucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startMultipart 模拟一次中断的分片上传：只上传了 parts 中的分片。
+func startMultipart(t *testing.T, u *Uploader, key string, parts ...[]byte) {
+	t.Helper()
+	ctx := context.Background()
+	uploadID, err := u.core.NewMultipartUpload(ctx, testBucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, part := range parts {
+		if _, err := u.core.PutObjectPart(ctx, testBucket, key, uploadID, i+1, bytes.NewReader(part), int64(len(part)), minio.PutObjectPartOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testData 返回 n 字节可复现的伪随机内容，seed 不同时内容不同。
+func testData(n int, seed byte) []byte {
+	b := make([]byte, n)
+	rand.NewChaCha8([32]byte{seed}).Read(b)
+	return b
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// localFile 把 data 写入临时目录下的 name，返回其路径。
+func localFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	writeFile(t, p, data)
+	return p
+}
+
+// verify 校验 localPath 中的所有文件，返回各文件不一致的原因（合并为一个错误）。
+func verify(u *Uploader, localPath, remoteDir string) error {
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	results, err := u.Verify(context.Background(), files, remoteDir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(results...)
+}
+
+func TestPartSize(t *testing.T) {
+	for _, tt := range []struct {
+		mb   int
+		want int64
+	}{{0, minPartSize}, {1, minPartSize}, {5, 5 << 20}, {64, 64 << 20}} {
+		config.Cfg = new(config.Config)
+		config.Cfg.S3.Endpoint = "127.0.0.1:9000"
+		config.Cfg.S3.Bucket = testBucket
+		config.Cfg.S3.PartSizeMB = tt.mb
+		u, err := NewUploader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.partSize != tt.want {
+			t.Errorf("Part_Size_MB = %d 时分片大小为 %d，应为 %d", tt.mb, u.partSize, tt.want)
+		}
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"/tv/Show":   "tv/Show",
+		"tv/Show/":   "tv/Show",
+		"//tv//Show": "tv/Show",
+		"/":          "",
+	} {
+		if got := objectKey(in); got != want {
+			t.Errorf("objectKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCompareETag(t *testing.T) {
+	data := testData(11<<20, 1)
+	lf := storage.LocalFile{AbsPath: localFile(t, "Movie.mkv", data), RelPath: "Movie.mkv", Size: int64(len(data))}
+	u := &Uploader{partSize: minPartSize}
+
+	whole := md5.Sum(data)
+	var sums []byte
+	for off := 0; off < len(data); off += minPartSize {
+		sum := md5.Sum(data[off:min(off+minPartSize, len(data))])
+		sums = append(sums, sum[:]...)
+	}
+	parts := md5.Sum(sums)
+
+	tests := []struct {
+		name string
+		etag string
+		want error
+	}{
+		{"单次上传", `"` + hex.EncodeToString(whole[:]) + `"`, nil},
+		{"单次上传不一致", `"` + strings.Repeat("0", 32) + `"`, storage.ErrMismatch},
+		{"分片上传", `"` + hex.EncodeToString(parts[:]) + `-3"`, nil},
+		{"分片上传不一致", `"` + strings.Repeat("0", 32) + `-3"`, storage.ErrMismatch},
+		// 以其他分片大小上传的对象无法复算 ETag，只能比对大小
+		{"分片数不同", `"` + strings.Repeat("0", 32) + `-2"`, nil},
+		// 服务端加密等情况下 ETag 不是 MD5
+		{"不是 MD5", `"kms-encrypted"`, nil},
+	}
+	for _, tt := range tests {
+		err := u.compareETag(lf, tt.etag)
+		if tt.want == nil && err != nil {
+			t.Errorf("%s: compareETag 返回错误: %v", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: compareETag 的错误为 %v，应为 %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestUploadSkipsExistingObjects(t *testing.T) {
+	u, s := newTestServer(t, testBucket, 5)
+	ctx := context.Background()
+	dir := filepath.Dir(localFile(t, "Show/ep1.mkv", testData(64<<10, 1)))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), testData(64<<10, 2))
+
+	if err := u.Upload(ctx, dir, "/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if puts := s.takePuts(); !slices.Equal(puts, []string{"tv/Show/ep1.mkv", "tv/Show/ep2.mkv"}) {
+		t.Errorf("PUT 请求为 %v", puts)
+	}
+	if err := verify(u, dir, "/tv"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// 大小相同但 ETag 不同的对象需要重新上传，一致的对象直接跳过
+	putObject(t, u, "tv/Show/ep2.mkv", testData(64<<10, 3))
+	s.takePuts()
+	if err := verify(u, dir, "/tv"); !errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("Verify 的错误为 %v，应为 ErrMismatch", err)
+	}
+	if err := u.Upload(ctx, dir, "/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if puts := s.takePuts(); !slices.Equal(puts, []string{"tv/Show/ep2.mkv"}) {
+		t.Errorf("PUT 请求为 %v，应只重新上传 ep2.mkv", puts)
+	}
+	if got := object(t, u, "tv/Show/ep2.mkv"); !bytes.Equal(got, testData(64<<10, 2)) {
+		t.Error("ep2.mkv 没有被覆盖为本地内容")
+	}
+}
+
+func TestMultipartResume(t *testing.T) {
+	u, s := newTestServer(t, testBucket, 5)
+	ctx := context.Background()
+	// 3 个分片: 5 MiB + 5 MiB + 1 MiB
+	data := testData(11<<20, 3)
+	localPath := localFile(t, "Movie.mkv", data)
+
+	// 上次中断时第 1 个分片已正确上传，第 2 个分片的内容是错的
+	startMultipart(t, u, "movies/Movie.mkv", data[:minPartSize], testData(minPartSize, 4))
+	s.takePuts()
+
+	if err := u.Upload(ctx, localPath, "movies"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if puts := s.takePuts(); !slices.Equal(puts, []string{"movies/Movie.mkv#2", "movies/Movie.mkv#3"}) {
+		t.Errorf("续传时的 PUT 请求为 %v，应只上传第 2、3 个分片", puts)
+	}
+	if got := object(t, u, "movies/Movie.mkv"); !bytes.Equal(got, data) {
+		t.Errorf("合并后的对象与本地文件不同 (%d 字节, 应为 %d 字节)", len(got), len(data))
+	}
+	if err := verify(u, localPath, "movies"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestMultipartResumeAfterPartSizeChange(t *testing.T) {
+	u, s := newTestServer(t, testBucket, 6)
+	ctx := context.Background()
+	data := testData(11<<20, 5)
+	localPath := localFile(t, "Movie.mkv", data)
+
+	// 中断的上传是以 5 MiB 分片进行的，之后 Part_Size_MB 改成了 6：旧分片的大小对不上，全部重新上传
+	startMultipart(t, u, "movies/Movie.mkv", data[:5<<20], data[5<<20:10<<20])
+	s.takePuts()
+
+	if err := u.Upload(ctx, localPath, "movies"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if puts := s.takePuts(); !slices.Equal(puts, []string{"movies/Movie.mkv#1", "movies/Movie.mkv#2"}) {
+		t.Errorf("PUT 请求为 %v，应按 6 MiB 重新上传 2 个分片", puts)
+	}
+	if got := object(t, u, "movies/Movie.mkv"); !bytes.Equal(got, data) {
+		t.Errorf("合并后的对象与本地文件不同 (%d 字节, 应为 %d 字节)", len(got), len(data))
+	}
+	if err := verify(u, localPath, "movies"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	u, _ := newTestServer(t, testBucket, 5)
+	ctx := context.Background()
+	putObject(t, u, "tv/Show/ep1.mkv", []byte("one"))
+	putObject(t, u, "tv/Show/Extras/info.nfo", []byte("nfo"))
+
+	// 对象存储没有目录，存在该前缀下的对象时视为目录
+	if info, err := u.Stat(ctx, "/tv/Show"); err != nil || !info.IsDir || info.Size != -1 {
+		t.Errorf("Stat(前缀) = %+v, %v", info, err)
+	}
+	if info, err := u.Stat(ctx, "/tv/Show/ep1.mkv"); err != nil || info.IsDir || info.Size != 3 {
+		t.Errorf("Stat(对象) = %+v, %v", info, err)
+	}
+	if _, err := u.Stat(ctx, "/tv/Sho"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Stat(前缀的一部分) 的错误为 %v，应为 ErrNotFound", err)
+	}
+
+	entries, err := u.List(ctx, "/tv/Show")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+		if (e.Name == "Extras") != e.IsDir {
+			t.Errorf("List 中 %+v 的 IsDir 不正确", e)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"Extras", "ep1.mkv"}) {
+		t.Errorf("List = %v", names)
+	}
+
+	if err := u.Delete(ctx, "/tv/Show"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := u.Stat(ctx, "/tv/Show"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("删除前缀后 Stat 的错误为 %v，应为 ErrNotFound", err)
+	}
+}
+
+func TestBucketNotFound(t *testing.T) {
+	u, _ := newTestServer(t, "no-such-bucket", 5)
+	localPath := localFile(t, "Movie.mkv", []byte("movie"))
+
+	// 存储桶不存在是配置问题，不代表远程文件缺失
+	if _, err := u.Stat(context.Background(), "movies/Movie.mkv"); err == nil || errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Stat 的错误为 %v，不应为 nil 或 ErrNotFound", err)
+	}
+	if err := verify(u, localPath, "movies"); err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("Verify 的错误为 %v，应为无法校验", err)
+	}
+}
+
+func TestVerifyPerFile(t *testing.T) {
+	u, s := newTestServer(t, testBucket, 5)
+	ctx := context.Background()
+	dir := filepath.Dir(localFile(t, "Show/ep1.mkv", testData(64<<10, 1)))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), testData(64<<10, 2))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("nfo"))
+	if err := u.Upload(ctx, dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	files, err := storage.WalkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putObject(t, u, "tv/Show/ep1.mkv", testData(64<<10, 3))
+	if err := u.client.RemoveObject(ctx, testBucket, "tv/Show/ep2.mkv", minio.RemoveObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.takeLists()
+	results, err := u.Verify(ctx, files, "tv")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// 不论有多少个文件、多少层目录，只列出一次前缀
+	if n := s.takeLists(); n != 1 {
+		t.Errorf("校验 %d 个文件列出了 %d 次对象，应只列出 1 次", len(files), n)
+	}
+	want := map[string]error{"Show/Extras/info.nfo": nil, "Show/ep1.mkv": storage.ErrMismatch, "Show/ep2.mkv": storage.ErrNotFound}
+	for i, lf := range files {
+		if w := want[lf.RelPath]; (w == nil) != (results[i] == nil) || !errors.Is(results[i], w) {
+			t.Errorf("%s: 结果为 %v，应为 %v", lf.RelPath, results[i], w)
+		}
+	}
+}
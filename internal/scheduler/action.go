@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"qbuploader/internal/config"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// afterAction 描述任务通过校验后对本地文件和 qB 任务记录的处理方式。
+type afterAction struct {
+	mode     string
+	category string
+	tag      string
+}
+
+// defaultAfterAction 返回 [Seeding_Policy] 中配置的处理方式。
+func defaultAfterAction() afterAction {
+	p := config.Cfg.SeedingPolicy
+	return afterAction{mode: p.ActionAfterProcess, category: p.ActionCategory, tag: p.ActionTag}
+}
+
+// keepsLocalFiles 报告该处理方式是否保留本地文件。
+func (a afterAction) keepsLocalFiles() bool {
+	return a.mode == config.ActionRemoveKeepFiles
+}
+
+func (a afterAction) String() string {
+	switch a.mode {
+	case config.ActionMoveToCategory:
+		return fmt.Sprintf("%s (%s)", a.mode, a.category)
+	case config.ActionSetTag:
+		return fmt.Sprintf("%s (%s)", a.mode, a.tag)
+	default:
+		return a.mode
+	}
+}
+
+// apply 对一批任务执行 qB 中的后续操作。
+func (a afterAction) apply(qbClient *qbittorrent.Client, hashes []string) error {
+	switch a.mode {
+	case config.ActionDelete, config.ActionRemoveKeepFiles:
+		// 本地文件已由我们自己处理（或按要求保留），因此这里不让 qB 删除文件
+		return qbClient.DeleteTorrents(hashes, false)
+	case config.ActionPause:
+		return qbClient.Pause(hashes)
+	case config.ActionMoveToCategory:
+		categories, err := qbClient.GetCategories()
+		if err != nil {
+			return fmt.Errorf("获取分类列表失败: %w", err)
+		}
+		if _, ok := categories[a.category]; !ok {
+			if err := qbClient.CreateCategory(a.category, ""); err != nil {
+				return fmt.Errorf("创建分类 '%s' 失败: %w", a.category, err)
+			}
+		}
+		return qbClient.SetCategory(hashes, a.category)
+	case config.ActionSetTag:
+		return qbClient.AddTags(hashes, a.tag)
+	case config.ActionDoNothing:
+		return nil
+	default:
+		return fmt.Errorf("未知的 Action_After_Process: %s", a.mode)
+	}
+}
@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"qbuploader/internal/baidupcs"
+	"qbuploader/internal/localfs"
+	"qbuploader/internal/rclone"
+	"qbuploader/internal/s3"
+	"qbuploader/internal/sftp"
+	"qbuploader/internal/storage"
+	"qbuploader/internal/webdav"
+)
+
+// newBackend 根据名称创建对应的存储后端。
+func newBackend(name string) (storage.Backend, error) {
+	switch name {
+	case "baidupcs":
+		return baidupcs.NewUploader(), nil
+	case "rclone":
+		return rclone.NewUploader(), nil
+	case "local":
+		return localfs.NewUploader()
+	case "s3":
+		return s3.NewUploader()
+	case "webdav":
+		return webdav.NewUploader()
+	case "sftp":
+		return sftp.NewUploader()
+	default:
+		return nil, fmt.Errorf("未知的存储后端: '%s'", name)
+	}
+}
+
+// newBackends 按配置顺序创建所有启用的存储后端。
+func newBackends(names []string) ([]storage.Backend, error) {
+	backends := make([]storage.Backend, 0, len(names))
+	for _, name := range names {
+		b, err := newBackend(name)
+		if err != nil {
+			return nil, fmt.Errorf("初始化存储后端 '%s' 失败: %w", name, err)
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+// checkNames 找出无法使用任务中某个文件名的目的地（见 storage.NameChecker），将其记录为 'failed'。
+// 返回其余可以上传的目的地，以及被拒绝的目的地的失败原因。
+func checkNames(backends []storage.Backend, infoHash string, files []storage.LocalFile) ([]storage.Backend, []string) {
+	var usable []storage.Backend
+	var rejected []string
+	for _, b := range backends {
+		checker, ok := b.(storage.NameChecker)
+		if !ok {
+			usable = append(usable, b)
+			continue
+		}
+		if err := checkFileNames(checker, files); err != nil {
+			log.Errorf("-> [%s] 无法上传: %v", b.Name(), err)
+			updateDestinationStatus(infoHash, b.Name(), "failed", err.Error())
+			rejected = append(rejected, fmt.Sprintf("%s: %v", b.Name(), err))
+			continue
+		}
+		usable = append(usable, b)
+	}
+	return usable, rejected
+}
+
+// checkFileNames 检查每个文件路径中的每一级名称，返回第一个无法使用的名称。
+func checkFileNames(checker storage.NameChecker, files []storage.LocalFile) error {
+	for _, f := range files {
+		for _, name := range strings.Split(f.RelPath, "/") {
+			if err := checker.CheckName(name); err != nil {
+				return fmt.Errorf("文件 '%s' 无法按原名上传 (%w)，请在 qB 中重命名后重新触发上传", f.RelPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// uploadAll 将文件依次上传到所有目的地，并在 task_destinations 表中记录每个目的地的状态。
+// 之前已经上传成功的目的地会被跳过。返回成功的目的地数量和失败原因列表。
+func uploadAll(ctx context.Context, backends []storage.Backend, infoHash string, files []storage.LocalFile, remoteDir string) (int, []string) {
+	statuses, err := getDestinationStatuses(infoHash)
+	if err != nil {
+		log.Warnf("-> 读取各目的地的上传记录失败，将全部重新上传: %v", err)
+	}
+
+	succeeded := 0
+	var failures []string
+	for _, b := range backends {
+		if s := statuses[b.Name()]; s == "success" || s == "verified" {
+			log.Infof("-> [%s] 此前已上传成功，跳过。", b.Name())
+			succeeded++
+			continue
+		}
+		log.Infof("-> [%s] 正在上传...", b.Name())
+		updateDestinationStatus(infoHash, b.Name(), "uploading", "开始上传")
+		if err := uploadFiles(ctx, b, infoHash, files, remoteDir); err != nil {
+			if ctx.Err() != nil {
+				updateDestinationStatus(infoHash, b.Name(), "pending", "上传被中断")
+				break
+			}
+			log.Errorf("-> [%s] 上传失败: %v", b.Name(), err)
+			updateDestinationStatus(infoHash, b.Name(), "failed", err.Error())
+			failures = append(failures, fmt.Sprintf("%s: %v", b.Name(), err))
+			continue
+		}
+		log.Infof("-> [%s] [OK] 上传成功。", b.Name())
+		updateDestinationStatus(infoHash, b.Name(), "success", "上传成功")
+		succeeded++
+	}
+	return succeeded, failures
+}
+
+// verifyAll 逐个校验所有目的地，返回校验通过的数量。record 为 true 时在 task_destinations 表中记录校验结果。
+func verifyAll(ctx context.Context, backends []storage.Backend, infoHash, localPath, remoteDir string, record bool) int {
+	verified := 0
+	for _, b := range backends {
+		err := verifyFiles(ctx, b, infoHash, localPath, remoteDir, record)
+		switch {
+		case err == nil:
+			log.Infof("    -> [%s] [OK] 校验成功！", b.Name())
+			if record {
+				updateDestinationStatus(infoHash, b.Name(), "verified", "校验成功")
+			}
+			verified++
+		case errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrMismatch):
+			log.Errorf("    -> [%s] 校验失败: %v", b.Name(), err)
+			if record {
+				updateDestinationStatus(infoHash, b.Name(), "verify_failed", err.Error())
+			}
+		default:
+			log.Warnf("    -> [%s] 无法校验（不代表远程文件缺失）: %v", b.Name(), err)
+			if record {
+				updateDestinationStatus(infoHash, b.Name(), "verify_error", err.Error())
+			}
+		}
+	}
+	return verified
+}
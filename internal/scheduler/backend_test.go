@@ -0,0 +1,215 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+)
+
+// memBackend 是保存在内存中的存储后端，只记录每个远程文件的大小。
+type memBackend struct {
+	name    string
+	statErr error           // 不为 nil 时所有 Stat 都返回它，模拟无法连接的目的地
+	fail    map[string]bool // 上传这些文件名时失败
+
+	mu      sync.Mutex
+	files   map[string]int64 // 远程路径 -> 大小
+	uploads []string         // 每次 Upload 的本地文件名
+}
+
+func newMemBackend(name string) *memBackend {
+	return &memBackend{name: name, fail: make(map[string]bool), files: make(map[string]int64)}
+}
+
+func (b *memBackend) Name() string { return b.name }
+
+func (b *memBackend) Upload(ctx context.Context, localPath, remoteDir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uploads = append(b.uploads, filepath.Base(localPath))
+	if b.fail[filepath.Base(localPath)] {
+		return fmt.Errorf("上传 '%s' 失败", filepath.Base(localPath))
+	}
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		b.files[storage.JoinRemote(remoteDir, f.RelPath)] = f.Size
+	}
+	return nil
+}
+
+func (b *memBackend) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := b.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *memBackend) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	if b.statErr != nil {
+		return nil, b.statErr
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	size, ok := b.files[remotePath]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	return &storage.FileInfo{Path: remotePath, Name: filepath.Base(remotePath), Size: size}, nil
+}
+
+func (b *memBackend) Delete(ctx context.Context, remotePath string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for p := range b.files {
+		if p == remotePath || strings.HasPrefix(p, remotePath+"/") {
+			delete(b.files, p)
+		}
+	}
+	return nil
+}
+
+func (b *memBackend) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// takeUploads 返回并清空记录的上传。
+func (b *memBackend) takeUploads() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	uploads := b.uploads
+	b.uploads = nil
+	return uploads
+}
+
+// destinationStatuses 返回任务在各目的地的状态。
+func destinationStatuses(t *testing.T, infoHash string) map[string]string {
+	t.Helper()
+	statuses, err := getDestinationStatuses(infoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statuses
+}
+
+func TestUploadAllAndVerifyAll(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	writeTestFile(t, filepath.Join(content, "ep2.mkv"), "episode two")
+	files, err := storage.WalkLocal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas, cloud, broken := newMemBackend("nas"), newMemBackend("cloud"), newMemBackend("broken")
+	broken.fail["ep2.mkv"] = true
+	backends := []storage.Backend{nas, cloud, broken}
+
+	succeeded, failures := uploadAll(context.Background(), backends, "abc", files, "/tv")
+	if succeeded != 2 || len(failures) != 1 || !strings.HasPrefix(failures[0], "broken: ") {
+		t.Fatalf("uploadAll = %d, %q，应为 2 个成功、broken 失败", succeeded, failures)
+	}
+	want := map[string]string{"nas": "success", "cloud": "success", "broken": "failed"}
+	if got := destinationStatuses(t, "abc"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("各目的地的状态为 %v，应为 %v", got, want)
+	}
+
+	// 重试时已经成功的目的地被跳过
+	nas.takeUploads()
+	cloud.takeUploads()
+	broken.fail["ep2.mkv"] = false
+	if succeeded, failures := uploadAll(context.Background(), backends, "abc", files, "/tv"); succeeded != 3 || len(failures) != 0 {
+		t.Fatalf("重试时 uploadAll = %d, %q，应为 3 个成功", succeeded, failures)
+	}
+	if uploads := append(nas.takeUploads(), cloud.takeUploads()...); len(uploads) != 0 {
+		t.Errorf("已经成功的目的地被重新上传: %v", uploads)
+	}
+
+	// 一个一致、一个缺少文件、一个无法连接：只有一个算校验通过
+	delete(cloud.files, "/tv/Show/ep2.mkv")
+	broken.statErr = errors.New("connection refused")
+	if verified := verifyAll(context.Background(), backends, "abc", content, "/tv", false); verified != 1 {
+		t.Errorf("verifyAll = %d，应为 1", verified)
+	}
+	want = map[string]string{"nas": "success", "cloud": "success", "broken": "success"}
+	if got := destinationStatuses(t, "abc"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("record 为 false 时各目的地的状态变为 %v，应保持 %v", got, want)
+	}
+	if verified := verifyAll(context.Background(), backends, "abc", content, "/tv", true); verified != 1 {
+		t.Errorf("verifyAll = %d，应为 1", verified)
+	}
+	want = map[string]string{"nas": "verified", "cloud": "verify_failed", "broken": "verify_error"}
+	if got := destinationStatuses(t, "abc"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("各目的地的状态为 %v，应为 %v", got, want)
+	}
+}
+
+// strictBackend 是不允许文件名中出现 ":" 的 memBackend。
+type strictBackend struct {
+	*memBackend
+}
+
+func (b strictBackend) CheckName(name string) error {
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("名称 %q 含有不允许的字符 ':'", name)
+	}
+	return nil
+}
+
+func TestCheckNames(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	writeTestFile(t, filepath.Join(content, "Extras: Behind the Scenes", "info.nfo"), "<episodedetails/>")
+	files, err := storage.WalkLocal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas, strict := newMemBackend("nas"), strictBackend{newMemBackend("baidupcs")}
+
+	usable, rejected := checkNames([]storage.Backend{nas, strict}, "abc", files)
+	if len(usable) != 1 || usable[0] != storage.Backend(nas) {
+		t.Errorf("可以上传的目的地为 %v，应只有 nas", usable)
+	}
+	if len(rejected) != 1 || !strings.Contains(rejected[0], "Extras: Behind the Scenes") {
+		t.Errorf("被拒绝的原因为 %q", rejected)
+	}
+	if got := destinationStatuses(t, "abc"); got["baidupcs"] != "failed" {
+		t.Errorf("被拒绝的目的地状态为 %q，应为 failed", got["baidupcs"])
+	}
+}
+
+func TestProcessUploadRejectsUnsupportedNames(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show: Pilot")
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	config.Cfg.Uploader.RemoteDir = "/backups"
+	config.Cfg.Uploader.RemotePath = "{name}"
+	config.Cfg.Uploader.Backends = []string{"baidupcs"}
+	config.Cfg.Uploader.Quorum = 1
+	config.Cfg.Uploader.Path = filepath.Join(dir, "BaiduPCS-Go-不应被调用")
+	if err := addTask("abc", "Show: Pilot", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquireLease("abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件名无法在百度网盘中使用，不会调用 BaiduPCS-Go，也不会安排重试
+	err := processUpload(context.Background(), "abc", "Show: Pilot", content)
+	if err == nil || !strings.Contains(err.Error(), "无法按原名上传") {
+		t.Fatalf("processUpload 的错误为 %v", err)
+	}
+	if status := taskStatus(t, "abc"); status != "dead" {
+		t.Errorf("任务的状态为 %q，应为 dead", status)
+	}
+}
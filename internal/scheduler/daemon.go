@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"qbuploader/internal/config"
+)
+
+// RunDaemonMode 以守护进程方式常驻运行：
+// 固定数量的工作线程从数据库中领取 'pending' 任务并上传，同时按固定间隔执行巡检清理。
+// 启用 [Daemon] Enabled 后，upload 命令只负责登记任务，实际上传全部由这里完成。
+func RunDaemonMode(ctx context.Context) error {
+	release, err := acquireLock(lockDaemon)
+	if errors.Is(err, errLocked) {
+		log.Warnf("-> 另一个守护进程正在运行，本次退出: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	cfg := config.Cfg.Daemon
+	log.Infof("===== [Daemon Mode] 启动，工作线程: %d，队列轮询间隔: %v =====", cfg.Workers, cfg.PollInterval)
+	if !cfg.Enabled {
+		log.Warn("-> [Daemon] Enabled 未开启，upload 命令仍会直接上传，不会把任务交给守护进程。")
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= cfg.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			runWorker(ctx, id, cfg.PollInterval)
+		}(i)
+	}
+
+	if config.Cfg.Watch.Enabled {
+		if releaseWatch, err := acquireLock(lockWatch); err != nil {
+			log.Warnf("-> 无法开启 qBittorrent 监视: %v", err)
+		} else {
+			defer releaseWatch()
+			log.Infof("-> 已开启 qBittorrent 监视，轮询间隔: %v", config.Cfg.Watch.Interval)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runWatchLoop(ctx, config.Cfg.Watch.Interval, config.Cfg.Watch.GracePeriod)
+			}()
+		}
+	}
+
+	if cfg.CleanupInterval > 0 {
+		log.Infof("-> 巡检清理间隔: %v", cfg.CleanupInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runCleanupLoop(ctx, cfg.CleanupInterval)
+		}()
+	} else {
+		log.Info("-> 已关闭定时巡检清理。")
+	}
+
+	wg.Wait()
+	log.Info("===== [Daemon Mode] 已退出 =====")
+	return nil
+}
+
+// runWorker 循环领取并处理上传任务，队列为空时等待 pollInterval 后再次检查。
+func runWorker(ctx context.Context, id int, pollInterval time.Duration) {
+	log.Debugf("-> 工作线程 #%d 已启动。", id)
+	// 先检查 ctx 再领取：退出时被中断的任务会标记为 'interrupted'，不检查的话会被立即重新领取
+	for ctx.Err() == nil {
+		task, err := claimPendingTask()
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Errorf("-> 工作线程 #%d 领取任务失败: %v", id, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		log.Infof("===== [Worker #%d] 开始上传: %s =====", id, task.TorrentName)
+		if err := processUpload(ctx, task.InfoHash, task.TorrentName, task.LocalPath.String); err != nil && ctx.Err() != nil {
+			log.Warnf("-> [Worker #%d] %s: %v", id, task.TorrentName, err)
+		} else if err != nil {
+			log.Errorf("-> [Worker #%d] %s: %v", id, task.TorrentName, err)
+		}
+		log.Infof("===== [Worker #%d] 处理完毕: %s =====", id, task.TorrentName)
+	}
+	log.Debugf("-> 工作线程 #%d 已退出。", id)
+}
+
+// runCleanupLoop 按固定间隔执行巡检清理，同一时间只会有一次巡检在运行。
+func runCleanupLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RunCleanupMode(ctx); err != nil {
+				log.Errorf("-> 巡检清理失败: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// diskStatus 是按剩余空间清理时下载目录所在磁盘的状态。
+type diskStatus struct {
+	Path      string `json:"path"`
+	FreeBytes uint64 `json:"free_bytes"`
+	HighWater uint64 `json:"high_water_bytes"`
+	LowWater  uint64 `json:"low_water_bytes"`
+}
+
+// checkDiskSpace 读取 [Disk_Space] Path 所在磁盘的剩余空间。
+func checkDiskSpace() (*diskStatus, error) {
+	free, err := storage.FreeSpace(config.Cfg.DiskSpace.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStatus{
+		Path:      config.Cfg.DiskSpace.Path,
+		FreeBytes: free,
+		HighWater: config.Cfg.DiskSpace.HighWater,
+		LowWater:  config.Cfg.DiskSpace.LowWater,
+	}, nil
+}
+
+// toFree 返回需要释放的空间：剩余空间不低于高水位时为 0，否则为到达低水位还差的空间。
+func (d *diskStatus) toFree() uint64 {
+	if d.FreeBytes >= d.HighWater {
+		return 0
+	}
+	return d.LowWater - d.FreeBytes
+}
+
+// onWatchedDisk 报告任务的内容是否位于 [Disk_Space] Path 中。
+func onWatchedDisk(t qbittorrent.Torrent) bool {
+	rel, err := filepath.Rel(config.Cfg.DiskSpace.Path, torrentContentPath(t))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sortForDiskSpace 按 [Disk_Space] Order 排列候选任务，最先清理的排在前面。
+func sortForDiskSpace(torrents []qbittorrent.Torrent) {
+	key := func(t qbittorrent.Torrent) int64 {
+		if config.Cfg.DiskSpace.Order == "least_active" {
+			return t.LastActivity
+		}
+		if t.CompletionOn > 0 {
+			return t.CompletionOn
+		}
+		return t.AddedOn
+	}
+	sort.SliceStable(torrents, func(i, j int) bool {
+		return key(torrents[i]) < key(torrents[j])
+	})
+}
@@ -0,0 +1,319 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"qbuploader/internal/database"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// collectTaskFiles 列出任务中需要上传的所有文件。
+// 能连接 qB 时以 qB 的文件列表为准（跳过未下载的文件），本地文件与其对不上时退回到遍历本地目录。
+func collectTaskFiles(qbClient *qbittorrent.Client, infoHash, contentPath string) ([]storage.LocalFile, error) {
+	if qbClient != nil {
+		files, err := torrentFiles(qbClient, infoHash, contentPath)
+		if err == nil {
+			return files, nil
+		}
+		log.Debugf("-> 无法使用 qB 的文件列表 (%v)，改为遍历本地目录。", err)
+	}
+	return storage.WalkLocal(contentPath)
+}
+
+// torrentFiles 根据 qB 的文件列表构造本地文件信息。qB 返回的文件名相对于保存路径，
+// 与 storage.WalkLocal 的 RelPath 布局一致（第一级是内容本身的名称）。
+func torrentFiles(qbClient *qbittorrent.Client, infoHash, contentPath string) ([]storage.LocalFile, error) {
+	list, err := qbClient.GetFilesInformation(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil || len(*list) == 0 {
+		return nil, fmt.Errorf("qB 返回的文件列表为空")
+	}
+	root := filepath.Dir(contentPath)
+	var files []storage.LocalFile
+	for _, f := range *list {
+		if f.Priority == 0 {
+			continue // 用户选择了不下载
+		}
+		absPath := filepath.Join(root, filepath.FromSlash(f.Name))
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() != f.Size {
+			return nil, fmt.Errorf("本地文件 '%s' 的大小 (%d) 与 qB 记录的 (%d) 不一致", absPath, info.Size(), f.Size)
+		}
+		files = append(files, storage.LocalFile{
+			AbsPath: absPath,
+			RelPath: filepath.ToSlash(f.Name),
+			Size:    f.Size,
+			ModTime: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// uploadFiles 逐个文件上传到一个目的地，并在 task_files 表中记录每个文件的状态。
+// 之前已经上传成功且大小未变的文件会被跳过，因此重试时只会补传缺少的文件。
+func uploadFiles(ctx context.Context, b storage.Backend, infoHash string, files []storage.LocalFile, remoteDir string) error {
+	statuses, err := syncTaskFiles(infoHash, b.Name(), files)
+	if err != nil {
+		return fmt.Errorf("登记文件列表失败: %w", err)
+	}
+	uploaded, skipped, failed := 0, 0, 0
+	var firstErr error
+	for i, f := range files {
+		if s := statuses[f.RelPath]; s == "success" || s == "verified" {
+			skipped++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		log.Infof("  -> [%s] (%d/%d) %s", b.Name(), i+1, len(files), f.RelPath)
+		updateFileStatus(infoHash, b.Name(), f, "uploading", "开始上传")
+		if err := b.Upload(ctx, f.AbsPath, storage.JoinRemote(remoteDir, path.Dir(f.RelPath))); err != nil {
+			if ctx.Err() != nil {
+				// 程序正在退出，文件重新登记为 'pending'，下次运行时重新上传
+				updateFileStatus(infoHash, b.Name(), f, "pending", "上传被中断")
+				return ctx.Err()
+			}
+			log.Errorf("  -> [%s] 文件 '%s' 上传失败: %v", b.Name(), f.RelPath, err)
+			updateFileStatus(infoHash, b.Name(), f, "failed", err.Error())
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		updateFileStatus(infoHash, b.Name(), f, "success", "上传成功")
+		uploaded++
+	}
+	if skipped > 0 {
+		log.Infof("  -> [%s] %d 个文件此前已上传成功，已跳过。", b.Name(), skipped)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d 个文件上传失败，首个错误: %w", failed, len(files), firstErr)
+	}
+	log.Debugf("  -> [%s] 本次上传了 %d 个文件。", b.Name(), uploaded)
+	if r, ok := b.(storage.DirTimesRestorer); ok && uploaded > 0 {
+		if root := contentRoot(files); root != "" {
+			if err := r.RestoreDirTimes(ctx, root, remoteDir); err != nil {
+				log.Warnf("  -> [%s] 恢复目录的修改时间失败: %v", b.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// contentRoot 返回文件所属内容的本地目录，即 RelPath 第一级对应的目录。单文件的任务没有目录，返回空字符串。
+func contentRoot(files []storage.LocalFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	top, _, ok := strings.Cut(files[0].RelPath, "/")
+	if !ok {
+		return ""
+	}
+	base := strings.TrimSuffix(files[0].AbsPath, filepath.FromSlash(files[0].RelPath))
+	return filepath.Join(base, top)
+}
+
+// verifyFiles 检查任务在一个目的地上登记过的每个文件的远程副本是否存在且一致，
+// 并在 task_files 表中记录每个文件的校验结果。
+// 远程明确不存在记为 'missing'，内容不一致记为 'mismatch'，网络错误等无法确认的情况记为 'verify_error'。
+// 后端实现了 storage.Verifier 时只列出一次远程目录批量比对，否则逐个文件调用 Stat。
+// record 为 false 时只校验，不写入数据库。
+func verifyFiles(ctx context.Context, b storage.Backend, infoHash, localPath, remoteDir string, record bool) error {
+	files, err := registeredFiles(infoHash, b.Name(), localPath)
+	if err != nil {
+		return err
+	}
+
+	var results []error
+	if v, ok := b.(storage.Verifier); ok {
+		results, err = v.Verify(ctx, files, remoteDir)
+	} else {
+		results, err = statFiles(ctx, b, files, remoteDir)
+	}
+	if err != nil {
+		// 整个目录都无法列出: 目录不存在时所有文件都算缺失，其余情况所有文件都无法校验
+		if ctx.Err() != nil {
+			return err
+		}
+		results = make([]error, len(files))
+		for i := range results {
+			results[i] = err
+		}
+	}
+
+	missing, mismatched, failed := 0, 0, 0
+	var firstErr error
+	for i, f := range files {
+		err := results[i]
+		switch {
+		case err == nil:
+			if record {
+				updateFileStatus(infoHash, b.Name(), f, "verified", "校验成功")
+			}
+			continue
+		case errors.Is(err, storage.ErrNotFound):
+			missing++
+			if record {
+				updateFileStatus(infoHash, b.Name(), f, "missing", err.Error())
+			}
+		case errors.Is(err, storage.ErrMismatch):
+			mismatched++
+			if record {
+				updateFileStatus(infoHash, b.Name(), f, "mismatch", err.Error())
+			}
+		default:
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if record {
+				updateFileStatus(infoHash, b.Name(), f, "verify_error", err.Error())
+			}
+		}
+		log.Debugf("    -> [%s] %v", b.Name(), err)
+	}
+	switch {
+	case missing > 0:
+		return fmt.Errorf("%d/%d 个文件在远程不存在: %w", missing, len(files), storage.ErrNotFound)
+	case mismatched > 0:
+		return fmt.Errorf("%d/%d 个文件内容不一致: %w", mismatched, len(files), storage.ErrMismatch)
+	case failed > 0:
+		return fmt.Errorf("%d/%d 个文件无法校验，首个错误: %w", failed, len(files), firstErr)
+	}
+	return nil
+}
+
+// statFiles 逐个文件调用 Stat 比对大小（后端提供 md5 时同时比对 md5），用于没有实现 storage.Verifier 的后端。
+// 返回值的含义与 storage.Verifier 相同。
+func statFiles(ctx context.Context, b storage.Backend, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	results := make([]error, len(files))
+	for i, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		remotePath := storage.JoinRemote(remoteDir, f.RelPath)
+		info, err := b.Stat(ctx, remotePath)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			results[i] = fmt.Errorf("远程缺少文件 '%s': %w", f.RelPath, storage.ErrNotFound)
+		case err != nil:
+			results[i] = err
+		case info.Size >= 0 && info.Size != f.Size:
+			results[i] = fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 远程 %d): %w", f.RelPath, f.Size, info.Size, storage.ErrMismatch)
+		case info.MD5 != "":
+			sum, err := storage.HashFile(f.AbsPath, "md5")
+			if err != nil {
+				results[i] = fmt.Errorf("计算本地文件 '%s' 的 md5 失败: %w", f.RelPath, err)
+			} else if sum != info.MD5 {
+				results[i] = fmt.Errorf("文件 '%s' 的 md5 不一致 (本地 %s, 远程 %s): %w", f.RelPath, sum, info.MD5, storage.ErrMismatch)
+			}
+		}
+	}
+	return results, nil
+}
+
+// --- task_files 表操作 ---
+
+// registeredFiles 返回任务在某个目的地上登记过的文件（即实际上传的文件，不包含用户选择不下载的文件），
+// 本地路径按 localPath 重新计算。没有登记记录的旧任务退回到遍历本地目录。
+func registeredFiles(infoHash, backend, localPath string) ([]storage.LocalFile, error) {
+	rows, err := database.DB.Query(`SELECT path, size_bytes FROM task_files WHERE info_hash = ? AND backend = ? ORDER BY path`, infoHash, backend)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件列表失败: %w", err)
+	}
+	defer rows.Close()
+	root := filepath.Dir(localPath)
+	var files []storage.LocalFile
+	for rows.Next() {
+		var f storage.LocalFile
+		if err := rows.Scan(&f.RelPath, &f.Size); err != nil {
+			return nil, fmt.Errorf("读取文件列表失败: %w", err)
+		}
+		f.AbsPath = filepath.Join(root, filepath.FromSlash(f.RelPath))
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取文件列表失败: %w", err)
+	}
+	if len(files) == 0 {
+		return storage.WalkLocal(localPath)
+	}
+	return files, nil
+}
+
+// syncTaskFiles 让 task_files 中某个目的地的文件列表与本次的文件列表一致：
+// 新文件登记为 'pending'，大小发生变化的文件重置为 'pending'，已不存在的文件被删除。
+// 返回每个文件当前的状态。
+func syncTaskFiles(infoHash, backend string, files []storage.LocalFile) (map[string]string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	upsert := `INSERT INTO task_files (info_hash, backend, path, size_bytes) VALUES (?, ?, ?, ?)
+		ON CONFLICT(info_hash, backend, path) DO UPDATE SET size_bytes = excluded.size_bytes,
+			status = CASE WHEN task_files.size_bytes = excluded.size_bytes THEN task_files.status ELSE 'pending' END,
+			updated_at = CURRENT_TIMESTAMP`
+	current := make(map[string]bool, len(files))
+	for _, f := range files {
+		if _, err := tx.Exec(upsert, infoHash, backend, f.RelPath, f.Size); err != nil {
+			return nil, err
+		}
+		current[f.RelPath] = true
+	}
+
+	rows, err := tx.Query(`SELECT path, status FROM task_files WHERE info_hash = ? AND backend = ?`, infoHash, backend)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string)
+	var stale []string
+	for rows.Next() {
+		var p, status string
+		if err := rows.Scan(&p, &status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if current[p] {
+			statuses[p] = status
+		} else {
+			stale = append(stale, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range stale {
+		if _, err := tx.Exec(`DELETE FROM task_files WHERE info_hash = ? AND backend = ? AND path = ?`, infoHash, backend, p); err != nil {
+			return nil, err
+		}
+	}
+	return statuses, tx.Commit()
+}
+
+// updateFileStatus 记录一个文件在某个目的地上的状态。
+func updateFileStatus(infoHash, backend string, f storage.LocalFile, status, message string) {
+	query := `INSERT INTO task_files (info_hash, backend, path, size_bytes, status, message) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(info_hash, backend, path) DO UPDATE SET size_bytes = excluded.size_bytes, status = excluded.status,
+			message = excluded.message, updated_at = CURRENT_TIMESTAMP`
+	if _, err := database.DB.Exec(query, infoHash, backend, f.RelPath, f.Size, status, message); err != nil {
+		log.Warnf("  -> 更新文件 '%s' 的状态失败: %v", f.RelPath, err)
+	}
+}
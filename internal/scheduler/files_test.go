@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/database"
+	"qbuploader/internal/localfs"
+	"qbuploader/internal/storage"
+)
+
+// fileStatuses 返回任务在某个目的地上各文件的状态。
+func fileStatuses(t *testing.T, infoHash, backend string) map[string]string {
+	t.Helper()
+	rows, err := database.DB.Query(`SELECT path, status FROM task_files WHERE info_hash = ? AND backend = ?`, infoHash, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var p, status string
+		if err := rows.Scan(&p, &status); err != nil {
+			t.Fatal(err)
+		}
+		statuses[p] = status
+	}
+	return statuses
+}
+
+func TestUploadFilesResendsOnlyMissing(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	for _, name := range []string{"ep1.mkv", "ep2.mkv", "ep3.mkv"} {
+		writeTestFile(t, filepath.Join(content, name), "episode "+name)
+	}
+	walk := func() []storage.LocalFile {
+		files, err := storage.WalkLocal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	b := newMemBackend("nas")
+	b.fail["ep2.mkv"] = true
+
+	if err := uploadFiles(context.Background(), b, "abc", walk(), "/tv"); err == nil {
+		t.Fatal("有文件上传失败时 uploadFiles 应返回错误")
+	}
+	want := map[string]string{"Show/ep1.mkv": "success", "Show/ep2.mkv": "failed", "Show/ep3.mkv": "success"}
+	if got := fileStatuses(t, "abc", "nas"); !maps.Equal(got, want) {
+		t.Errorf("各文件的状态为 %v，应为 %v", got, want)
+	}
+
+	// 重试时只补传失败的文件
+	b.takeUploads()
+	b.fail["ep2.mkv"] = false
+	if err := uploadFiles(context.Background(), b, "abc", walk(), "/tv"); err != nil {
+		t.Fatalf("uploadFiles: %v", err)
+	}
+	if got := b.takeUploads(); !slices.Equal(got, []string{"ep2.mkv"}) {
+		t.Errorf("重试时上传了 %v，应只上传 ep2.mkv", got)
+	}
+
+	// 大小发生变化的文件重新上传，已经不存在的文件不再登记
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one, extended")
+	if err := os.Remove(filepath.Join(content, "ep3.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadFiles(context.Background(), b, "abc", walk(), "/tv"); err != nil {
+		t.Fatalf("uploadFiles: %v", err)
+	}
+	if got := b.takeUploads(); !slices.Equal(got, []string{"ep1.mkv"}) {
+		t.Errorf("内容变化后上传了 %v，应只上传 ep1.mkv", got)
+	}
+	want = map[string]string{"Show/ep1.mkv": "success", "Show/ep2.mkv": "success"}
+	if got := fileStatuses(t, "abc", "nas"); !maps.Equal(got, want) {
+		t.Errorf("各文件的状态为 %v，应为 %v", got, want)
+	}
+}
+
+func TestUploadFilesRestoresDirTimes(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	writeTestFile(t, filepath.Join(content, "Extras", "info.nfo"), "<episodedetails/>")
+	for _, p := range []string{filepath.Join(content, "Extras"), content} {
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Cfg.Local.Root = filepath.Join(dir, "nas")
+	b, err := localfs.NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := storage.WalkLocal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := uploadFiles(context.Background(), b, "abc", files, "tv"); err != nil {
+		t.Fatalf("uploadFiles: %v", err)
+	}
+	// 逐个文件上传后，目录的修改时间仍与本地一致
+	for _, p := range []string{"tv/Show", "tv/Show/Extras"} {
+		info, err := os.Stat(filepath.Join(dir, "nas", filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("'%s' 的修改时间为 %v，应为 %v", p, info.ModTime(), modTime)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"qbuploader/internal/database"
+)
+
+const (
+	// heartbeatInterval 是上传期间刷新租约心跳的间隔。
+	heartbeatInterval = 30 * time.Second
+	// leaseTimeout 是心跳超过多久未刷新就认为持有者已经退出（崩溃、被杀或重启）。
+	leaseTimeout = 5 * time.Minute
+)
+
+// leaseOwner 标识当前进程，形如 "主机名:PID"，写入 tasks.lease_owner。
+var leaseOwner = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// leaseExpiry 是 SQLite datetime('now', ?) 的参数，早于它的心跳视为已经过期。
+func leaseExpiry() string {
+	return fmt.Sprintf("-%d seconds", int64(leaseTimeout/time.Second))
+}
+
+// every 在后台每隔 interval 执行一次 fn，返回的函数用于停止。
+func every(interval time.Duration, fn func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// startHeartbeat 在后台定时刷新任务的心跳，返回的函数用于停止刷新。
+func startHeartbeat(infoHash string) func() {
+	return every(heartbeatInterval, func() {
+		query := `UPDATE tasks SET heartbeat_at = CURRENT_TIMESTAMP WHERE info_hash = ? AND lease_owner = ?`
+		if _, err := database.DB.Exec(query, infoHash, leaseOwner); err != nil {
+			log.Warnf("-> 刷新任务心跳失败: %v", err)
+		}
+	})
+}
+
+// errTaskBusy 表示任务正在被另一个进程上传或清理。
+var errTaskBusy = errors.New("任务正在被另一个进程处理")
+
+// activeLease 是判断任务租约仍然有效的 SQL 条件，参数为 leaseExpiry()。
+const activeLease = `upload_status IN ('uploading', 'cleaning') AND COALESCE(heartbeat_at, updated_at) >= datetime('now', ?)`
+
+// taskBusy 返回带有租约持有者信息的 errTaskBusy。
+func taskBusy(infoHash string) error {
+	var status, owner string
+	query := `SELECT upload_status, COALESCE(lease_owner, '') FROM tasks WHERE info_hash = ?`
+	if err := database.DB.QueryRow(query, infoHash).Scan(&status, &owner); err != nil || owner == "" {
+		return errTaskBusy
+	}
+	return fmt.Errorf("%w (状态: %s, 持有者: %s)", errTaskBusy, status, owner)
+}
+
+// releaseLease 清除当前进程持有的租约。
+func releaseLease(infoHash string) error {
+	query := `UPDATE tasks SET lease_owner = NULL, heartbeat_at = NULL WHERE info_hash = ? AND lease_owner = ?`
+	_, err := database.DB.Exec(query, infoHash, leaseOwner)
+	return err
+}
+
+// RecoverStaleTasks 在启动时扫描停留在 'uploading' 或 'cleaning' 但心跳已经过期的任务。
+// 这些任务的上传进程已经不在了（被杀、崩溃或机器重启），记录了本地路径的任务会重置为 'pending' 重新排队，
+// 其余的标记为 'failed' 并立即允许重试。
+func RecoverStaleTasks() error {
+	query := `SELECT info_hash, torrent_name, COALESCE(lease_owner, ''), COALESCE(local_path, '') FROM tasks
+		WHERE upload_status = 'uploading' AND COALESCE(heartbeat_at, updated_at) < datetime('now', ?)`
+	rows, err := database.DB.Query(query, leaseExpiry())
+	if err != nil {
+		return fmt.Errorf("查询中断的上传任务失败: %w", err)
+	}
+	type staleTask struct {
+		infoHash, torrentName, owner, localPath string
+	}
+	var stale []staleTask
+	for rows.Next() {
+		var t staleTask
+		if err := rows.Scan(&t.infoHash, &t.torrentName, &t.owner, &t.localPath); err != nil {
+			rows.Close()
+			return fmt.Errorf("查询中断的上传任务失败: %w", err)
+		}
+		stale = append(stale, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("查询中断的上传任务失败: %w", err)
+	}
+
+	for _, t := range stale {
+		status := "pending"
+		if t.localPath == "" {
+			status = "failed"
+		}
+		owner := t.owner
+		if owner == "" {
+			owner = "未知进程"
+		}
+		message := fmt.Sprintf("上传进程 (%s) 意外退出，已自动恢复", owner)
+		update := `UPDATE tasks SET upload_status = ?, message = ?, next_retry_at = CURRENT_TIMESTAMP,
+				lease_owner = NULL, heartbeat_at = NULL
+			WHERE info_hash = ? AND upload_status = 'uploading' AND COALESCE(lease_owner, '') = ?`
+		if _, err := database.DB.Exec(update, status, message, t.infoHash, t.owner); err != nil {
+			log.Errorf("-> 恢复任务 '%s' 失败: %v", t.torrentName, err)
+			continue
+		}
+		log.Warnf("-> 发现中断的上传任务 '%s' (持有者: %s)，已重置为 '%s'。", t.torrentName, owner, status)
+	}
+	if len(stale) > 0 {
+		log.Infof("-> [OK] 共恢复了 %d 个中断的上传任务。", len(stale))
+	}
+
+	// 清理进程中途退出时本地内容可能只删除了一部分，恢复为 'success' 后下次巡检会重新校验
+	update := `UPDATE tasks SET upload_status = 'success', message = '清理进程意外退出，等待下次巡检',
+			lease_owner = NULL, heartbeat_at = NULL
+		WHERE upload_status = 'cleaning' AND COALESCE(heartbeat_at, updated_at) < datetime('now', ?)`
+	res, err := database.DB.Exec(update, leaseExpiry())
+	if err != nil {
+		return fmt.Errorf("恢复中断的清理任务失败: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Warnf("-> 发现 %d 个中断的清理任务，已重置为 'success'，下次巡检时重新校验。", n)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecoverStaleTasks(t *testing.T) {
+	useTestDB(t)
+	insert := `INSERT INTO tasks (info_hash, torrent_name, local_path, upload_status, lease_owner, heartbeat_at)
+		VALUES (?, ?, ?, ?, 'nas:1234', datetime('now', ?))`
+	exec(t, insert, "stale", "Stale", "/downloads/Stale", "uploading", "-10 minutes")
+	exec(t, insert, "nopath", "NoPath", nil, "uploading", "-10 minutes")
+	exec(t, insert, "alive", "Alive", "/downloads/Alive", "uploading", "-1 minutes")
+	exec(t, insert, "cleaning", "Cleaning", "/downloads/Cleaning", "cleaning", "-10 minutes")
+	exec(t, insert, "cleaning2", "Cleaning2", "/downloads/Cleaning2", "cleaning", "-1 minutes")
+
+	if err := RecoverStaleTasks(); err != nil {
+		t.Fatalf("RecoverStaleTasks: %v", err)
+	}
+	want := map[string]string{
+		"stale":     "pending", // 记录了本地路径，重新排队
+		"nopath":    "failed",  // 没有本地路径，等待重试
+		"alive":     "uploading",
+		"cleaning":  "success", // 下次巡检重新校验
+		"cleaning2": "cleaning",
+	}
+	for h, status := range want {
+		if got := taskStatus(t, h); got != status {
+			t.Errorf("任务 %s 的状态为 %q，应为 %q", h, got, status)
+		}
+	}
+	task, err := claimPendingTask()
+	if err != nil || task.InfoHash != "stale" {
+		t.Errorf("恢复后领取到的任务为 %+v, %v，应为 stale", task, err)
+	}
+}
+
+func TestLeaseExclusion(t *testing.T) {
+	useTestDB(t)
+	for _, h := range []string{"aaa", "bbb"} {
+		exec(t, `INSERT INTO tasks (info_hash, torrent_name, local_path, upload_status) VALUES (?, ?, '/downloads', 'success')`, h, h)
+	}
+
+	// 清理中的任务不能被重新上传
+	if err := claimForCleanup("aaa", []string{"bbb"}); err != nil {
+		t.Fatalf("claimForCleanup: %v", err)
+	}
+	if err := acquireLease("aaa"); !errors.Is(err, errTaskBusy) {
+		t.Errorf("清理中的任务 acquireLease 的错误为 %v，应为 errTaskBusy", err)
+	}
+	if err := claimForCleanup("aaa", nil); !errors.Is(err, errTaskBusy) {
+		t.Errorf("重复 claimForCleanup 的错误为 %v，应为 errTaskBusy", err)
+	}
+	if err := releaseCleanup("aaa", "删除本地文件失败"); err != nil {
+		t.Fatal(err)
+	}
+	if status := taskStatus(t, "aaa"); status != "success" {
+		t.Errorf("releaseCleanup 后状态为 %q，应为 success", status)
+	}
+
+	// 上传中的任务不能被清理，共用内容的任务正在上传时也不能清理
+	if err := acquireLease("bbb"); err != nil {
+		t.Fatalf("acquireLease: %v", err)
+	}
+	if err := claimForCleanup("bbb", nil); !errors.Is(err, errTaskBusy) {
+		t.Errorf("上传中的任务 claimForCleanup 的错误为 %v，应为 errTaskBusy", err)
+	}
+	if err := claimForCleanup("aaa", []string{"bbb"}); !errors.Is(err, errTaskBusy) {
+		t.Errorf("共用内容的任务正在上传时 claimForCleanup 的错误为 %v，应为 errTaskBusy", err)
+	}
+	if status := taskStatus(t, "aaa"); status != "success" {
+		t.Errorf("claimForCleanup 失败后 aaa 的状态为 %q，应保持 success", status)
+	}
+	if err := acquireLease("bbb"); !errors.Is(err, errTaskBusy) {
+		t.Errorf("租约未过期时重复 acquireLease 的错误为 %v，应为 errTaskBusy", err)
+	}
+
+	// 持有者的心跳过期后，其他进程可以接手
+	exec(t, `UPDATE tasks SET heartbeat_at = datetime('now', '-10 minutes') WHERE info_hash = 'bbb'`)
+	if err := acquireLease("bbb"); err != nil {
+		t.Errorf("租约过期后 acquireLease: %v", err)
+	}
+}
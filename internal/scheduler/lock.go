@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"qbuploader/internal/database"
+)
+
+// 操作锁的名称。同名的操作同一时间只能有一个进程在执行。
+const (
+	lockCleanup = "cleanup"
+	lockWatch   = "watch"
+	lockDaemon  = "daemon"
+)
+
+// errLocked 表示同名的操作正在由另一个进程执行。
+var errLocked = errors.New("操作正在由另一个进程执行")
+
+// acquireLock 取得名为 name 的操作锁（记录在 locks 表中），返回的函数用于释放。
+// 持有期间会定时刷新心跳；心跳超过 leaseTimeout 未刷新的锁视为持有者已经退出，可以直接接管。
+// 锁已被其他进程持有时返回 errLocked。
+func acquireLock(name string) (func(), error) {
+	query := `INSERT INTO locks (name, owner) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET owner = excluded.owner, acquired_at = CURRENT_TIMESTAMP, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE locks.heartbeat_at < datetime('now', ?)`
+	res, err := database.DB.Exec(query, name, leaseOwner, leaseExpiry())
+	if err != nil {
+		return nil, fmt.Errorf("获取 '%s' 锁失败: %w", name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var owner string
+		var since time.Time
+		if err := database.DB.QueryRow(`SELECT owner, acquired_at FROM locks WHERE name = ?`, name).Scan(&owner, &since); err != nil {
+			return nil, errLocked
+		}
+		return nil, fmt.Errorf("%w (持有者: %s, 开始于: %s)", errLocked, owner, since.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	stop := every(heartbeatInterval, func() {
+		query := `UPDATE locks SET heartbeat_at = CURRENT_TIMESTAMP WHERE name = ? AND owner = ?`
+		if _, err := database.DB.Exec(query, name, leaseOwner); err != nil {
+			log.Warnf("-> 刷新 '%s' 锁的心跳失败: %v", name, err)
+		}
+	})
+	return func() {
+		stop()
+		if _, err := database.DB.Exec(`DELETE FROM locks WHERE name = ? AND owner = ?`, name, leaseOwner); err != nil {
+			log.Warnf("-> 释放 '%s' 锁失败: %v", name, err)
+		}
+	}, nil
+}
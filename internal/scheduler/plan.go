@@ -0,0 +1,395 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// 本地内容的处理方式。
+const (
+	localDelete = "delete"
+	localTrash  = "trash"
+	localKeep   = "keep"
+)
+
+// cleanupItem 是清理计划中的一个任务：校验结果、本地内容的处理方式以及要执行的 qB 操作。
+type cleanupItem struct {
+	InfoHash   string   `json:"info_hash"`
+	Name       string   `json:"name"`
+	Policy     string   `json:"policy"`
+	Backends   []string `json:"backends"`
+	RemoteDir  string   `json:"remote_dir"`
+	Verified   int      `json:"verified"`
+	Quorum     int      `json:"quorum"`
+	LocalPath  string   `json:"local_path"`
+	Local      string   `json:"local_action,omitempty"`
+	SizeBytes  int64    `json:"size_bytes"`
+	FreedBytes int64    `json:"freed_bytes"` // 实际能释放的空间：移入回收站、保留的文件以及还有其他硬链接的文件不计入
+	Action     string   `json:"qb_action,omitempty"`
+	SharedWith []string `json:"shared_with,omitempty"` // 因共用内容而一起处理的其他任务
+	SkipReason string   `json:"skip_reason,omitempty"`
+
+	action afterAction
+	hashes []string // 本任务及 SharedWith 中的任务
+}
+
+// plannedAction 是对一组任务执行的 qB 操作。
+type plannedAction struct {
+	Action string   `json:"action"`
+	Hashes []string `json:"hashes"`
+
+	action afterAction
+}
+
+// cleanupPlan 是一次清理巡检的完整计划。
+type cleanupPlan struct {
+	Items []cleanupItem
+	Disk  *diskStatus // 仅在启用 [Disk_Space] 时有值
+}
+
+// actions 按首次出现的顺序汇总未被跳过的任务要执行的 qB 操作。
+func (p *cleanupPlan) actions() []plannedAction {
+	var actions []plannedAction
+	index := make(map[afterAction]int)
+	for _, item := range p.Items {
+		if item.SkipReason != "" {
+			continue
+		}
+		i, ok := index[item.action]
+		if !ok {
+			i = len(actions)
+			index[item.action] = i
+			actions = append(actions, plannedAction{Action: item.action.String(), action: item.action})
+		}
+		actions[i].Hashes = append(actions[i].Hashes, item.hashes...)
+	}
+	return actions
+}
+
+// freedBytes 返回按计划清理后实际能释放的本地空间。
+func (p *cleanupPlan) freedBytes() int64 {
+	var total int64
+	for _, item := range p.Items {
+		if item.SkipReason == "" {
+			total += item.FreedBytes
+		}
+	}
+	return total
+}
+
+// planCleanup 筛选满足清理条件的已上传任务，逐个校验远程副本，并决定本地内容和 qB 任务的处理方式。
+// 它不会修改本地文件和 qB；record 为 false 时校验结果也不会写入数据库。
+//
+// 启用 [Disk_Space] 时，只有剩余空间低于高水位才会清理：满足清理条件的任务按 Order 排序，
+// 依次加入计划，直到预计释放的空间足以让剩余空间达到低水位。
+func planCleanup(ctx context.Context, qbClient *qbittorrent.Client, record bool) (*cleanupPlan, error) {
+	plan := &cleanupPlan{}
+	var toFree uint64
+	if config.Cfg.DiskSpace.Enabled {
+		disk, err := checkDiskSpace()
+		if err != nil {
+			return nil, fmt.Errorf("获取 '%s' 所在磁盘的剩余空间失败: %w", config.Cfg.DiskSpace.Path, err)
+		}
+		plan.Disk = disk
+		toFree = disk.toFree()
+		log.Infof("-> 磁盘剩余空间: %s (高水位 %s, 低水位 %s)", formatSize(int64(disk.FreeBytes)), formatSize(int64(disk.HighWater)), formatSize(int64(disk.LowWater)))
+		if toFree == 0 {
+			log.Info("-> 剩余空间充足，无需清理。")
+			return plan, nil
+		}
+		log.Infof("-> 剩余空间低于高水位，需要释放 %s。", formatSize(int64(toFree)))
+	}
+
+	log.Info("-> 正在获取任务列表与上传记录...")
+	allTorrents, err := qbClient.GetTorrents(qbittorrent.TorrentFilterOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("获取 qB 任务列表失败: %w", err)
+	}
+	uploadedHashes, err := getTasksByStatus("success")
+	if err != nil {
+		return nil, fmt.Errorf("从数据库获取已上传列表失败: %w", err)
+	}
+	log.Infof("-> [OK] 数据获取完毕: %d 个 qB 任务, %d 条已上传记录。", len(allTorrents), len(uploadedHashes))
+
+	log.Info("-> 正在筛选满足清理条件的任务...")
+	log.Infof("-> 全局清理条件: %s", config.Cfg.SeedingPolicy.CleanupCondition)
+	if plan.Disk != nil {
+		log.Infof("-> 按剩余空间清理，顺序: %s", config.Cfg.DiskSpace.Order)
+	}
+	var tasksToProcess []qbittorrent.Torrent
+	policies := make(map[string]taskPolicy)
+	for _, t := range allTorrents {
+		if !uploadedHashes[t.Hash] {
+			continue
+		}
+		pol := policyFor(qbClient, t)
+		if pol.skipUpload {
+			continue
+		}
+		if plan.Disk != nil && !onWatchedDisk(t) {
+			log.Debugf("  -> 任务 '%s' 的内容不在 %s 中，跳过。", t.Name, plan.Disk.Path)
+			continue
+		}
+		if !pol.condition.Match(torrentFields(t)) {
+			log.Debugf("  -> 任务 '%s' 尚未满足 %s 的清理条件 %s (状态: %s, 分享率: %.2f, 做种时长: %s)。", t.Name, pol, pol.condition, t.State, t.Ratio, time.Duration(t.SeedingTime)*time.Second)
+			continue
+		}
+		tasksToProcess = append(tasksToProcess, t)
+		policies[t.Hash] = pol
+		log.Infof("  -> 任务 '%s' 符合所有条件 (%s)，已加入处理队列。", t.Name, pol)
+	}
+
+	if len(tasksToProcess) == 0 {
+		return plan, nil
+	}
+	log.Infof("-> 筛选完毕，共 %d 个任务待处理。", len(tasksToProcess))
+	if plan.Disk != nil {
+		sortForDiskSpace(tasksToProcess)
+	}
+	backendCache := make(map[string][]storage.Backend)
+	backendsFor := func(names []string) ([]storage.Backend, error) {
+		key := strings.Join(names, ",")
+		if backends, ok := backendCache[key]; ok {
+			return backends, nil
+		}
+		backends, err := newBackends(names)
+		if err == nil {
+			backendCache[key] = backends
+		}
+		return backends, err
+	}
+	handled := make(map[string]bool)
+	var freed uint64
+	for i, t := range tasksToProcess {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("巡检被中断: %w", ctx.Err())
+		}
+		if plan.Disk != nil && freed >= toFree {
+			log.Infof("-> 预计释放 %s 后剩余空间将达到低水位，其余 %d 个任务保留做种。", formatSize(int64(freed)), len(tasksToProcess)-i)
+			break
+		}
+		log.Infof("--> [ %d / %d ] 正在处理任务: %s", i+1, len(tasksToProcess), t.Name)
+		if handled[t.Hash] {
+			log.Info("    -> 已随共用内容的任务一起处理，跳过。")
+			continue
+		}
+		pol := policies[t.Hash]
+		item := cleanupItem{InfoHash: t.Hash, Name: t.Name, Policy: pol.String(), action: pol.action}
+		skip := func(reason string) {
+			item.SkipReason = reason
+			plan.Items = append(plan.Items, item)
+		}
+
+		task, err := getTaskByHash(t.Hash)
+		if err != nil {
+			log.Errorf("    -> 读取上传记录失败: %v。跳过此任务。", err)
+			skip(fmt.Sprintf("读取上传记录失败: %v", err))
+			continue
+		}
+		target := cleanupTarget(task, t, pol)
+		item.Backends, item.Quorum = target.backends, target.quorum
+		item.LocalPath, item.RemoteDir = target.localPath, target.remoteDir
+		backends, err := backendsFor(target.backends)
+		if err != nil {
+			log.Errorf("    -> 初始化存储后端失败: %v。跳过此任务。", err)
+			skip(fmt.Sprintf("初始化存储后端失败: %v", err))
+			continue
+		}
+		log.Info("    -> 正在校验网盘文件...")
+		contentPath, remoteDir := target.localPath, target.remoteDir
+		log.Debugf("    -> 本地路径: %s, 上传目录: %s", contentPath, remoteDir)
+		item.Verified = verifyAll(ctx, backends, t.Hash, contentPath, remoteDir, record)
+		if item.Verified < target.quorum {
+			log.Errorf("    -> [严重] 最终校验失败！仅 %d/%d 个目的地校验通过，未达到要求的 %d 个。为安全起见，将不会删除任何文件！", item.Verified, len(backends), target.quorum)
+			skip(fmt.Sprintf("仅 %d/%d 个目的地校验通过", item.Verified, len(backends)))
+			continue
+		}
+		log.Infof("    -> [OK] 校验成功！(%d/%d 个目的地)", item.Verified, len(backends))
+
+		sharing := sharingTorrents(allTorrents, t.Hash, contentPath)
+		if len(sharing) > 0 {
+			if reason := crossSeedBlocked(sharing, policies); reason != "" {
+				skip(reason)
+				continue
+			}
+			if reason := verifySharing(ctx, qbClient, sharing, contentPath, pol.action, uploadedHashes, backendsFor, record); reason != "" {
+				skip(reason)
+				continue
+			}
+		}
+		item.Local = localDelete
+		if config.Cfg.Trash.Enabled {
+			item.Local = localTrash
+		}
+		if pol.action.keepsLocalFiles() {
+			log.Infof("    -> 按照 %s 的设置，保留本地文件: %s", pol.action, contentPath)
+			item.Local = localKeep
+		} else {
+			keepFiles, reason := checkHardlinks(contentPath)
+			if reason != "" {
+				skip(reason)
+				continue
+			}
+			if keepFiles {
+				item.Local = localKeep
+			}
+		}
+		if files, err := storage.WalkLocal(contentPath); err == nil {
+			for _, f := range files {
+				item.SizeBytes += f.Size
+			}
+		}
+		if item.Local == localDelete {
+			if item.FreedBytes, err = storage.FreeableSize(contentPath); err != nil {
+				log.Warnf("    -> 统计可释放空间失败: %v", err)
+			}
+		}
+		if plan.Disk != nil {
+			// 只按真正能释放的空间计算，否则水位在计划中达到了，磁盘却仍然是满的
+			if item.FreedBytes == 0 {
+				log.Infof("    -> 清理此任务不会释放空间，跳过此任务。")
+				skip("清理后不会释放空间 (保留本地文件或文件还有其他硬链接)")
+				continue
+			}
+			freed += uint64(item.FreedBytes)
+		}
+
+		item.Action = pol.action.String()
+		item.hashes = []string{t.Hash}
+		for _, other := range sharing {
+			item.SharedWith = append(item.SharedWith, other.Hash)
+			item.hashes = append(item.hashes, other.Hash)
+		}
+		for _, h := range item.hashes {
+			handled[h] = true
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return plan, nil
+}
+
+// executeCleanup 按计划清理本地内容、归档任务，并对 qB 任务执行相应的操作。
+// 每个任务先标记为 'cleaning' 再动手，正在上传的任务会被跳过；本地内容清理失败的任务不会执行 qB 操作。
+// ctx 被取消时不再清理剩余的任务，但已经清理了本地内容的任务仍会执行 qB 操作。
+func executeCleanup(ctx context.Context, qbClient *qbittorrent.Client, plan *cleanupPlan) {
+	for i := range plan.Items {
+		item := &plan.Items[i]
+		if item.SkipReason != "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			log.Warnf("-> 巡检被中断，跳过任务 '%s'。", item.Name)
+			item.SkipReason = "巡检被中断"
+			continue
+		}
+		if err := claimForCleanup(item.InfoHash, item.SharedWith); err != nil {
+			log.Warnf("-> 任务 '%s' 无法清理: %v。跳过此任务。", item.Name, err)
+			item.SkipReason = err.Error()
+			continue
+		}
+		if item.Local != localKeep {
+			log.Infof("-> 正在清理任务 '%s' 的本地内容...", item.Name)
+			if err := removeLocal(item.hashes, item.LocalPath); err != nil {
+				log.Errorf("    -> 删除本地文件失败: %v。跳过此任务。", err)
+				item.SkipReason = fmt.Sprintf("删除本地文件失败: %v", err)
+				releaseCleanup(item.InfoHash, item.SkipReason)
+				continue
+			}
+		}
+		for _, h := range item.hashes {
+			archiveTask(h)
+		}
+	}
+	for _, pa := range plan.actions() {
+		if pa.action.mode == config.ActionDoNothing {
+			log.Infof("-> %d 个任务的处理方式为 do_nothing，保持 qBittorrent 中的任务不变。", len(pa.Hashes))
+			continue
+		}
+		log.Infof("-> 正在对 %d 个任务执行 qBittorrent 操作: %s", len(pa.Hashes), pa.Action)
+		if err := pa.action.apply(qbClient, pa.Hashes); err != nil {
+			log.Errorf("-> 执行 qBittorrent 操作 %s 失败: %v", pa.Action, err)
+		} else {
+			log.Infof("-> [OK] qBittorrent 操作 %s 执行成功。", pa.Action)
+		}
+	}
+}
+
+// printPlanTable 以表格形式输出清理计划。
+func printPlanTable(w io.Writer, plan *cleanupPlan) error {
+	if d := plan.Disk; d != nil {
+		fmt.Fprintf(w, "磁盘剩余空间: %s (%s)，高水位 %s，低水位 %s\n\n", formatSize(int64(d.FreeBytes)), d.Path, formatSize(int64(d.HighWater)), formatSize(int64(d.LowWater)))
+	}
+	if len(plan.Items) == 0 {
+		_, err := fmt.Fprintln(w, "没有需要清理的任务。")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "任务\t校验\t本地内容\t大小\t释放\tqB 操作\t说明")
+	for _, item := range plan.Items {
+		verified := fmt.Sprintf("%d/%d (需要 %d)", item.Verified, len(item.Backends), item.Quorum)
+		local, action, note := item.Local, item.Action, item.SkipReason
+		if item.SkipReason != "" {
+			local, action = "-", "跳过"
+		} else if len(item.SharedWith) > 0 {
+			note = fmt.Sprintf("同时处理共用内容的 %d 个任务: %s", len(item.SharedWith), strings.Join(item.SharedWith, ", "))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s %s\t%s\t%s\t%s\t%s\n", item.Name, verified, local, item.LocalPath, formatSize(item.SizeBytes), formatSize(item.FreedBytes), action, note)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	actions := plan.actions()
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "不会执行任何 qB 操作。")
+	}
+	for _, pa := range actions {
+		fmt.Fprintf(w, "qB 操作 %s: %d 个任务 (%s)\n", pa.Action, len(pa.Hashes), strings.Join(pa.Hashes, ", "))
+	}
+	_, err := fmt.Fprintf(w, "预计释放本地空间: %s\n", formatSize(plan.freedBytes()))
+	return err
+}
+
+// printPlanJSON 以 JSON 形式输出清理计划。
+func printPlanJSON(w io.Writer, plan *cleanupPlan) error {
+	out := struct {
+		Disk       *diskStatus     `json:"disk,omitempty"`
+		Tasks      []cleanupItem   `json:"tasks"`
+		Actions    []plannedAction `json:"qb_actions"`
+		FreedBytes int64           `json:"freed_bytes"`
+	}{plan.Disk, plan.Items, plan.actions(), plan.freedBytes()}
+	if out.Tasks == nil {
+		out.Tasks = []cleanupItem{}
+	}
+	if out.Actions == nil {
+		out.Actions = []plannedAction{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// formatSize 将字节数格式化为便于阅读的形式。
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
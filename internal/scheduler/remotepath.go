@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"qbuploader/internal/remotepath"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// remoteDirFor 按任务生效的上传目录模板计算上传目录。
+func remoteDirFor(pol taskPolicy, vars map[string]string) string {
+	return storage.JoinRemote(pol.remoteRoot, remotepath.Render(pol.remotePath, vars))
+}
+
+// templateVars 收集上传目录模板中可用的变量。t 为 nil 时只有名称、InfoHash 和当前日期可用。
+func templateVars(t *qbittorrent.Torrent, tracker, infoHash, torrentName string) map[string]string {
+	date := time.Now()
+	vars := map[string]string{
+		"name":            torrentName,
+		"info_hash":       infoHash,
+		"info_hash_short": infoHash[:min(8, len(infoHash))],
+	}
+	if t != nil {
+		vars["category"] = t.Category
+		tag, _, _ := strings.Cut(t.Tags, ",")
+		vars["tag"] = strings.TrimSpace(tag)
+		vars["tracker"] = tracker
+		if t.CompletionOn > 0 {
+			date = time.Unix(t.CompletionOn, 0)
+		} else if t.AddedOn > 0 {
+			date = time.Unix(t.AddedOn, 0)
+		}
+	}
+	vars["year"] = fmt.Sprintf("%04d", date.Year())
+	vars["month"] = fmt.Sprintf("%02d", int(date.Month()))
+	vars["day"] = fmt.Sprintf("%02d", date.Day())
+	return vars
+}
+
+// torrentTracker 返回任务的 Tracker 域名。qB 只在有可用 Tracker 时才填写 tracker 字段，
+// 为空时再从 Tracker 列表中取第一个 HTTP/UDP Tracker。
+func torrentTracker(qbClient *qbittorrent.Client, t qbittorrent.Torrent) string {
+	if domain := trackerDomain(t.Tracker); domain != "" {
+		return domain
+	}
+	trackers, err := qbClient.GetTorrentTrackers(t.Hash)
+	if err != nil {
+		log.Debugf("  -> 获取任务 '%s' 的 Tracker 列表失败: %v", t.Name, err)
+		return ""
+	}
+	for _, tr := range trackers {
+		if domain := trackerDomain(tr.Url); domain != "" {
+			return domain
+		}
+	}
+	return ""
+}
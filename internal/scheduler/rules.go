@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/policy"
+	"qbuploader/internal/remotepath"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// taskPolicy 是某个任务最终生效的设置：全局设置被第一条匹配的 [Rule:名称] 覆盖后的结果。
+type taskPolicy struct {
+	rule       string // 匹配到的规则名，空表示使用全局设置
+	skipUpload bool
+	remoteRoot string
+	remotePath string // 上传目录模板
+	backends   []string
+	quorum     int
+	condition  *policy.Condition
+	action     afterAction
+}
+
+func (p taskPolicy) String() string {
+	if p.rule == "" {
+		return "全局设置"
+	}
+	return fmt.Sprintf("规则 [Rule:%s]", p.rule)
+}
+
+// defaultPolicy 返回全局设置。
+func defaultPolicy() taskPolicy {
+	return taskPolicy{
+		remoteRoot: config.Cfg.Uploader.RemoteDir,
+		remotePath: config.Cfg.Uploader.RemotePath,
+		backends:   config.Cfg.Uploader.Backends,
+		quorum:     config.Cfg.Uploader.Quorum,
+		condition:  config.Cfg.SeedingPolicy.CleanupCondition,
+		action:     defaultAfterAction(),
+	}
+}
+
+// policyFor 返回任务生效的设置。只有规则中填写了 Trackers 时才会解析任务的 Tracker 域名，
+// 解析方式与上传目录模板中的 {tracker} 相同。
+func policyFor(qbClient *qbittorrent.Client, t qbittorrent.Torrent) taskPolicy {
+	tracker := ""
+	for _, rule := range config.Cfg.Rules {
+		if len(rule.Trackers) > 0 {
+			tracker = torrentTracker(qbClient, t)
+			break
+		}
+	}
+	return matchPolicy(t, tracker)
+}
+
+// matchPolicy 返回任务生效的设置，tracker 是已经解析好的 Tracker 域名。
+func matchPolicy(t qbittorrent.Torrent, tracker string) taskPolicy {
+	p := defaultPolicy()
+	for _, rule := range config.Cfg.Rules {
+		if !ruleMatches(rule, t, tracker) {
+			continue
+		}
+		p.rule = rule.Name
+		p.skipUpload = rule.SkipUpload
+		if rule.RemoteDir != "" {
+			p.remoteRoot = rule.RemoteDir
+		}
+		if rule.RemotePath != "" {
+			p.remotePath = rule.RemotePath
+		}
+		if len(rule.Backends) > 0 {
+			p.backends = rule.Backends
+			p.quorum = rule.Quorum
+		}
+		if rule.CleanupCondition != nil {
+			p.condition = rule.CleanupCondition
+		}
+		if rule.Action != "" {
+			p.action = afterAction{mode: rule.Action, category: rule.ActionCategory, tag: rule.ActionTag}
+		}
+		break
+	}
+	return p
+}
+
+// resolveUpload 在上传前确定任务生效的设置和上传目录。
+// 只有配置了规则、或上传目录模板用到了 qB 中的信息时才会连接 qB 读取任务的分类、标签等属性，
+// 此时返回的客户端可以继续用于读取文件列表；否则返回的客户端为 nil。
+func resolveUpload(infoHash, torrentName string) (taskPolicy, string, *qbittorrent.Client, error) {
+	pol := defaultPolicy()
+	if len(config.Cfg.Rules) == 0 && !remotepath.NeedsTorrentInfo(pol.remotePath) {
+		return pol, remoteDirFor(pol, templateVars(nil, "", infoHash, torrentName)), nil, nil
+	}
+	qbClient, err := newQBClient()
+	if err != nil {
+		return taskPolicy{}, "", nil, err
+	}
+	torrents, err := qbClient.GetTorrents(qbittorrent.TorrentFilterOptions{Hashes: []string{infoHash}})
+	if err != nil {
+		return taskPolicy{}, "", nil, fmt.Errorf("获取 qB 任务信息失败: %w", err)
+	}
+	if len(torrents) == 0 {
+		log.Warnf("-> qB 中找不到该任务，无法匹配规则，将使用全局设置。")
+		return pol, remoteDirFor(pol, templateVars(nil, "", infoHash, torrentName)), nil, nil
+	}
+	t := torrents[0]
+	tracker := torrentTracker(qbClient, t)
+	pol = matchPolicy(t, tracker)
+	return pol, remoteDirFor(pol, templateVars(&t, tracker, infoHash, torrentName)), qbClient, nil
+}
+
+// ruleMatches 判断任务是否满足规则中填写的所有匹配条件。tracker 是任务的 Tracker 域名，见 torrentTracker。
+func ruleMatches(rule config.Rule, t qbittorrent.Torrent, tracker string) bool {
+	if len(rule.Categories) > 0 && !containsFold(rule.Categories, t.Category) {
+		return false
+	}
+	if len(rule.Tags) > 0 {
+		matched := false
+		for _, tag := range strings.Split(t.Tags, ",") {
+			if containsFold(rule.Tags, strings.TrimSpace(tag)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.Trackers) > 0 && !trackerMatches(rule.Trackers, tracker) {
+		return false
+	}
+	if len(rule.SavePaths) > 0 && !savePathMatches(rule.SavePaths, t.SavePath) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackerDomain 返回 Tracker 地址中的域名（小写），无法解析时返回空字符串。
+func trackerDomain(tracker string) string {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// trackerMatches 判断 Tracker 域名是否为列表中的某个域名或其子域名。
+func trackerMatches(domains []string, host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// savePathMatches 判断保存路径是否位于列表中的某个目录之下。
+func savePathMatches(prefixes []string, savePath string) bool {
+	if savePath == "" {
+		return false
+	}
+	savePath = filepath.Clean(savePath)
+	for _, prefix := range prefixes {
+		if savePath == prefix || strings.HasPrefix(savePath, prefix+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"testing"
+
+	"qbuploader/internal/config"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+func useRules(rules ...config.Rule) {
+	config.Cfg = new(config.Config)
+	config.Cfg.Rules = rules
+}
+
+func TestPolicyForTracker(t *testing.T) {
+	useRules(
+		config.Rule{Name: "movies", Categories: []string{"movies"}},
+		config.Rule{Name: "pt", Trackers: []string{"example.org"}, SkipUpload: true},
+	)
+	tests := []struct {
+		name    string
+		tracker string   // qB 返回的 tracker 字段
+		list    []string // Tracker 列表
+		want    string
+	}{
+		{"tracker 字段", "https://tracker.example.org/announce?passkey=x", nil, "pt"},
+		{"tracker 字段不匹配", "https://tracker.example.net/announce", []string{"https://tracker.example.org/announce"}, ""},
+		// 没有可用的 Tracker 时 qB 不填写 tracker 字段，需要从 Tracker 列表中取
+		{"tracker 字段为空", "", []string{"** [DHT] **", "** [PeX] **", "udp://tracker.example.org:6969/announce"}, "pt"},
+		{"Tracker 列表也不匹配", "", []string{"** [DHT] **", "https://tracker.example.net/announce"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := qbittorrent.Torrent{Hash: "abc", Name: "Show", Tracker: tt.tracker}
+			qb := newFakeQB(t, torrent)
+			qb.trackers["abc"] = tt.list
+			if got := policyFor(qb.client(t), torrent); got.rule != tt.want {
+				t.Errorf("匹配到的规则为 %q，应为 %q", got.rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyForWithoutTrackerRules(t *testing.T) {
+	useRules(config.Rule{Name: "movies", Categories: []string{"movies"}})
+	torrent := qbittorrent.Torrent{Hash: "abc", Name: "Movie", Category: "movies"}
+	qb := newFakeQB(t, torrent)
+	qb.trackers["abc"] = []string{"https://tracker.example.org/announce"}
+
+	if got := policyFor(qb.client(t), torrent); got.rule != "movies" {
+		t.Errorf("匹配到的规则为 %q，应为 movies", got.rule)
+	}
+	// 规则都没有填写 Trackers 时不需要读取 Tracker 列表
+	if n := qb.called("torrents/trackers"); n != 0 {
+		t.Errorf("读取了 %d 次 Tracker 列表，应为 0 次", n)
+	}
+}
@@ -1,16 +1,18 @@
 package scheduler
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"strings"
+	"time"
 
-	"qbuploader/internal/baidupcs"
 	"qbuploader/internal/config"
 	"qbuploader/internal/database"
 	"qbuploader/internal/logger"
+	"qbuploader/internal/policy"
 
 	"github.com/autobrr/go-qbittorrent" // <<<--- 【最终修正】修正了这里的拼写错误
 )
@@ -18,7 +20,7 @@ import (
 var log = logger.Log
 
 // RunUploadMode 函数...
-func RunUploadMode(infoHash, torrentName, contentPath string) error {
+func RunUploadMode(ctx context.Context, infoHash, torrentName, contentPath string) error {
 	log.Infof("===== [Upload Mode] 任务: %s =====", torrentName)
 	log.Debugf("InfoHash: %s, 本地路径: %s", infoHash, contentPath)
 	task, err := getTaskByHash(infoHash)
@@ -29,25 +31,115 @@ func RunUploadMode(infoHash, torrentName, contentPath string) error {
 		log.Infof("-> 任务已在数据库中标记为上传成功，跳过本次上传。")
 		return nil
 	}
+	if config.Cfg.Daemon.Enabled {
+		if err := enqueueTask(infoHash, torrentName, contentPath); err != nil {
+			return fmt.Errorf("数据库登记任务失败: %w", err)
+		}
+		log.Info("-> [OK] 已启用守护进程模式，任务已加入上传队列。")
+		log.Info("===== [Upload Mode] 执行完毕 =====")
+		return nil
+	}
 	log.Info("-> 正在登记任务并准备上传...")
-	if err := addTask(infoHash, torrentName); err != nil {
+	if err := addTask(infoHash, torrentName, contentPath); err != nil {
 		return fmt.Errorf("数据库登记任务失败: %w", err)
 	}
-	updateTaskStatus(infoHash, "uploading", "开始上传")
-	uploader := baidupcs.NewUploader()
-	err = uploader.Upload(contentPath, config.Cfg.Uploader.RemoteDir, torrentName)
-	if err != nil {
-		updateTaskStatus(infoHash, "failed", err.Error())
-		return fmt.Errorf("上传失败: %w", err)
+	if err := acquireLease(infoHash); err != nil {
+		if errors.Is(err, errTaskBusy) {
+			log.Warnf("-> %v，本次不再重复上传。", err)
+			log.Info("===== [Upload Mode] 执行完毕 =====")
+			return nil
+		}
+		return fmt.Errorf("数据库登记任务失败: %w", err)
+	}
+	if err := processUpload(ctx, infoHash, torrentName, contentPath); err != nil {
+		return err
 	}
-	updateTaskStatus(infoHash, "success", "上传成功")
-	log.Info("-> [OK] 上传成功！")
 	log.Info("===== [Upload Mode] 执行完毕 =====")
 	return nil
 }
 
+// processUpload 将一个已登记为 'uploading' 的任务上传到所有目的地，并根据成功条件更新任务状态。
+// 调用方需要先通过 acquireLease 或 claimPendingTask 取得租约，处理期间会持续刷新心跳，结束后释放租约。
+func processUpload(ctx context.Context, infoHash, torrentName, contentPath string) error {
+	stopHeartbeat := startHeartbeat(infoHash)
+	defer releaseLease(infoHash)
+	defer stopHeartbeat()
+
+	pol, remoteDir, qbClient, err := resolveUpload(infoHash, torrentName)
+	if err != nil {
+		markTaskFailed(infoHash, err.Error())
+		return err
+	}
+	if pol.rule != "" {
+		log.Infof("-> 任务匹配 %s。", pol)
+	}
+	if pol.skipUpload {
+		updateTaskStatus(infoHash, "skipped", fmt.Sprintf("%s 设置为不上传", pol))
+		log.Infof("-> %s 设置为不上传，跳过 '%s'。", pol, torrentName)
+		return nil
+	}
+	backends, err := newBackends(pol.backends)
+	if err != nil {
+		markTaskFailed(infoHash, err.Error())
+		return err
+	}
+	// 重试时沿用第一次确定的上传目录，避免模板中的日期等变量变化后传到新的位置
+	if task, err := getTaskByHash(infoHash); err == nil && task.RemotePath.Valid && task.RemotePath.String != "" {
+		remoteDir = task.RemotePath.String
+	}
+	files, err := collectTaskFiles(qbClient, infoHash, contentPath)
+	if err != nil {
+		markTaskFailed(infoHash, err.Error())
+		return err
+	}
+	var sizeBytes int64
+	for _, f := range files {
+		sizeBytes += f.Size
+	}
+	if err := recordUploadTarget(infoHash, contentPath, remoteDir, pol.backends, sizeBytes, len(files)); err != nil {
+		markTaskFailed(infoHash, err.Error())
+		return fmt.Errorf("记录上传信息失败: %w", err)
+	}
+	log.Infof("-> 上传目录: %s (%d 个文件, %d 字节)", remoteDir, len(files), sizeBytes)
+	usable, rejected := checkNames(backends, infoHash, files)
+	quorum := pol.quorum
+	if len(usable) < quorum {
+		// 文件名不变就不可能成功，重试没有意义
+		msg := fmt.Sprintf("仅 %d/%d 个目的地可以上传，无法达到要求的 %d 个: %s", len(usable), len(backends), quorum, strings.Join(rejected, "; "))
+		log.Errorf("-> %s", msg)
+		updateTaskStatus(infoHash, "dead", msg)
+		return fmt.Errorf("上传失败: %s", msg)
+	}
+	succeeded, failures := uploadAll(ctx, usable, infoHash, files, remoteDir)
+	failures = append(rejected, failures...)
+	if ctx.Err() != nil {
+		markTaskInterrupted(infoHash)
+		return fmt.Errorf("上传被中断: %w", ctx.Err())
+	}
+	if succeeded < quorum {
+		msg := fmt.Sprintf("仅 %d/%d 个目的地上传成功，未达到要求的 %d 个: %s", succeeded, len(backends), quorum, strings.Join(failures, "; "))
+		markTaskFailed(infoHash, msg)
+		return fmt.Errorf("上传失败: %s", msg)
+	}
+	if len(failures) > 0 {
+		log.Warnf("-> 部分目的地上传失败，但已满足成功条件 (%d/%d): %s", succeeded, len(backends), strings.Join(failures, "; "))
+	}
+	updateTaskStatus(infoHash, "success", fmt.Sprintf("上传成功 (%d/%d 个目的地)", succeeded, len(backends)))
+	log.Infof("-> [OK] '%s' 上传成功！", torrentName)
+	return nil
+}
+
 // RunCleanupMode 函数...
-func RunCleanupMode() error {
+func RunCleanupMode(ctx context.Context) error {
+	release, err := acquireLock(lockCleanup)
+	if errors.Is(err, errLocked) {
+		log.Warnf("-> 另一个巡检清理正在运行，本次退出: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
 	log.Info("===== [Cleanup Mode] 开始执行巡检 =====")
 
 	log.Info("-> 正在执行数据库维护...")
@@ -55,9 +147,59 @@ func RunCleanupMode() error {
 	if err != nil {
 		log.Warnf("-> 数据库维护失败: %v", err)
 	} else if rowsAffected > 0 {
-		log.Infof("-> [OK] 成功清理了 %d 条过期的数据库记录。", rowsAffected)
+		log.Infof("-> [OK] 成功精简了 %d 条过期的归档记录。", rowsAffected)
 	}
 
+	if config.Cfg.Trash.Enabled || config.Cfg.Trash.Dir != "" {
+		purgeTrash()
+	}
+
+	if !config.Cfg.Daemon.Enabled {
+		log.Info("-> 正在处理待上传及到期重试的任务...")
+		drainQueue(ctx)
+	}
+
+	qbClient, err := newQBClient()
+	if err != nil {
+		return err
+	}
+	plan, err := planCleanup(ctx, qbClient, true)
+	if err != nil {
+		return err
+	}
+	if len(plan.Items) == 0 {
+		log.Info("-> 没有需要处理的任务。")
+	} else {
+		executeCleanup(ctx, qbClient, plan)
+	}
+	log.Info("===== [Cleanup Mode] 巡检完毕 =====")
+	return nil
+}
+
+// RunCleanupPlan 按照与 RunCleanupMode 相同的筛选和校验流程生成清理计划并输出，
+// 不删除文件、不操作 qB，也不写入数据库。format 为 "table" 或 "json"。
+func RunCleanupPlan(ctx context.Context, w io.Writer, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("不支持的输出格式 '%s'，只能是 table 或 json", format)
+	}
+	log.Info("===== [Cleanup Mode] 演练模式，不会修改任何内容 =====")
+	qbClient, err := newQBClient()
+	if err != nil {
+		return err
+	}
+	plan, err := planCleanup(ctx, qbClient, false)
+	if err != nil {
+		return err
+	}
+	log.Info("===== [Cleanup Mode] 演练完毕 =====")
+	if format == "json" {
+		return printPlanJSON(w, plan)
+	}
+	return printPlanTable(w, plan)
+}
+
+// newQBClient 创建 qBittorrent 客户端并登录。
+func newQBClient() (*qbittorrent.Client, error) {
 	log.Info("-> 正在连接 qBittorrent...")
 	qbConfig := qbittorrent.Config{
 		Host:     config.Cfg.QBittorrent.Host,
@@ -66,103 +208,210 @@ func RunCleanupMode() error {
 	}
 	qbClient := qbittorrent.NewClient(qbConfig)
 	if err := qbClient.Login(); err != nil {
-		return fmt.Errorf("登录 qBittorrent 失败: %w", err)
+		return nil, fmt.Errorf("登录 qBittorrent 失败: %w", err)
 	}
 	log.Info("-> [OK] 登录成功。")
+	return qbClient, nil
+}
 
-	log.Info("-> 正在获取任务列表与上传记录...")
-	allTorrents, err := qbClient.GetTorrents(qbittorrent.TorrentFilterOptions{})
-	if err != nil {
-		return fmt.Errorf("获取 qB 任务列表失败: %w", err)
-	}
-	uploadedHashes, err := getTasksByStatus("success")
-	if err != nil {
-		return fmt.Errorf("从数据库获取已上传列表失败: %w", err)
+// --- 数据库操作封装 ---
+// addTask 登记任务。qB 再次触发回调时视为手动重试，重置重试计数；正在被其他进程处理的任务保持不变。
+func addTask(infoHash, torrentName, localPath string) error {
+	query := `INSERT INTO tasks (info_hash, torrent_name, local_path) VALUES (?, ?, ?)
+		ON CONFLICT(info_hash) DO UPDATE SET torrent_name = excluded.torrent_name, local_path = excluded.local_path,
+			attempts = 0, next_retry_at = NULL
+		WHERE NOT (` + activeLease + `)`
+	_, err := database.DB.Exec(query, infoHash, torrentName, localPath, leaseExpiry())
+	return err
+}
+
+// enqueueTask 将任务登记为 'pending'，等待守护进程上传。正在上传或已完成的任务不受影响。
+func enqueueTask(infoHash, torrentName, localPath string) error {
+	query := `INSERT INTO tasks (info_hash, torrent_name, local_path, upload_status, message) VALUES (?, ?, ?, 'pending', '等待上传')
+		ON CONFLICT(info_hash) DO UPDATE SET torrent_name = excluded.torrent_name, local_path = excluded.local_path,
+			upload_status = excluded.upload_status, message = excluded.message, attempts = 0, next_retry_at = NULL
+		WHERE tasks.upload_status NOT IN ('uploading', 'cleaning', 'success', 'archived')`
+	_, err := database.DB.Exec(query, infoHash, torrentName, localPath)
+	return err
+}
+
+// claimPendingTask 原子地取出最早登记的一个 'pending' 或 'interrupted' 任务，或已到重试时间的 'failed' 任务，
+// 并将其标记为 'uploading'。没有待处理任务时返回 sql.ErrNoRows。
+func claimPendingTask() (*database.Task, error) {
+	query := `UPDATE tasks SET upload_status = 'uploading', message = '开始上传',
+			lease_owner = ?, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE info_hash = (
+			SELECT info_hash FROM tasks
+			WHERE (upload_status IN ('pending', 'interrupted') OR (upload_status = 'failed' AND next_retry_at <= CURRENT_TIMESTAMP))
+				AND local_path IS NOT NULL AND local_path != ''
+			ORDER BY created_at LIMIT 1
+		)
+		RETURNING info_hash, torrent_name, local_path`
+	var t database.Task
+	if err := database.DB.QueryRow(query, leaseOwner).Scan(&t.InfoHash, &t.TorrentName, &t.LocalPath); err != nil {
+		return nil, err
 	}
-	log.Infof("-> [OK] 数据获取完毕: %d 个 qB 任务, %d 条已上传记录。", len(allTorrents), len(uploadedHashes))
+	t.UploadStatus = "uploading"
+	return &t, nil
+}
 
-	log.Info("-> 正在筛选满足清理条件的任务...")
-	targetStates := strings.Split(config.Cfg.SeedingPolicy.Cleanup_Target_States, ",")
-	stateMap := make(map[string]bool)
-	for _, s := range targetStates {
-		stateMap[strings.TrimSpace(s)] = true
+func updateTaskStatus(infoHash, status, message string) error {
+	query := `UPDATE tasks SET upload_status = ?, message = ? WHERE info_hash = ?`
+	_, err := database.DB.Exec(query, status, message, infoHash)
+	return err
+}
+
+// torrentFields 将 qB 任务转换为清理条件使用的字段。
+func torrentFields(t qbittorrent.Torrent) policy.Torrent {
+	fields := policy.Torrent{
+		Ratio:       t.Ratio,
+		SeedingTime: time.Duration(t.SeedingTime) * time.Second,
+		NumComplete: t.NumComplete,
+		State:       string(t.State),
 	}
-	var tasksToProcess []qbittorrent.Torrent
-	for _, t := range allTorrents {
-		if stateMap[string(t.State)] && uploadedHashes[t.Hash] {
-			tasksToProcess = append(tasksToProcess, t)
-			log.Infof("  -> 任务 '%s' 符合所有条件，已加入处理队列。", t.Name)
-		}
+	if t.LastActivity > 0 {
+		fields.LastActivity = time.Since(time.Unix(t.LastActivity, 0))
 	}
+	return fields
+}
 
-	if len(tasksToProcess) == 0 {
-		log.Info("-> 没有需要处理的任务。")
-		log.Info("===== [Cleanup Mode] 巡检完毕 =====")
-		return nil
+// acquireLease 将任务标记为 'uploading'，并记录当前进程为租约持有者。
+// 任务正被其他进程上传或清理（租约未过期）时返回 errTaskBusy。
+func acquireLease(infoHash string) error {
+	query := `UPDATE tasks SET upload_status = 'uploading', message = '开始上传',
+		lease_owner = ?, heartbeat_at = CURRENT_TIMESTAMP WHERE info_hash = ? AND NOT (` + activeLease + `)`
+	res, err := database.DB.Exec(query, leaseOwner, infoHash, leaseExpiry())
+	if err != nil {
+		return err
 	}
-	log.Infof("-> 筛选完毕，共 %d 个任务待处理。", len(tasksToProcess))
-	uploader := baidupcs.NewUploader()
-	var hashesToDeleteFromQB []string
-	for i, t := range tasksToProcess {
-		log.Infof("--> [ %d / %d ] 正在处理任务: %s", i+1, len(tasksToProcess), t.Name)
-		log.Info("    -> 正在校验网盘文件...")
-		exists, err := uploader.CheckFileExists(config.Cfg.Uploader.RemoteDir, t.Name)
-		if err != nil {
-			log.Warnf("    -> 网盘文件校验时发生错误，跳过此任务: %v", err)
-			continue
-		}
-		if !exists {
-			log.Errorf("    -> [严重] 最终校验失败！网盘上未找到文件 '%s'。为安全起见，将不会删除任何文件！", t.Name)
-			continue
-		}
-		log.Info("    -> [OK] 校验成功！")
-		contentPath := filepath.Join(t.SavePath, t.Name)
-		log.Infof("    -> 正在删除本地文件: %s", contentPath)
-		if err := os.RemoveAll(contentPath); err != nil {
-			log.Errorf("    -> 删除本地文件失败: %v。跳过此任务。", err)
-			continue
+	if n, _ := res.RowsAffected(); n == 0 {
+		return taskBusy(infoHash)
+	}
+	return nil
+}
+
+// claimForCleanup 将已上传成功的任务标记为 'cleaning'，期间上传流程不会再领取它。
+// 本任务或共用内容的其他任务已经不是 'success'（例如正在重新上传）时返回 errTaskBusy。
+func claimForCleanup(infoHash string, shared []string) error {
+	for _, h := range shared {
+		var ok bool
+		query := `SELECT EXISTS (SELECT 1 FROM tasks WHERE info_hash = ? AND upload_status = 'success')`
+		if err := database.DB.QueryRow(query, h).Scan(&ok); err != nil {
+			return err
 		}
-		log.Infof("    -> [OK] 本地文件已删除。")
-		hashesToDeleteFromQB = append(hashesToDeleteFromQB, t.Hash)
-		archiveTask(t.Hash)
-	}
-	if len(hashesToDeleteFromQB) > 0 {
-		deleteFiles := false
-		log.Infof("-> 准备从 qBittorrent 中批量删除 %d 个任务记录...", len(hashesToDeleteFromQB))
-		if err := qbClient.DeleteTorrents(hashesToDeleteFromQB, deleteFiles); err != nil {
-			log.Errorf("-> 从 qBittorrent 删除任务失败: %v", err)
-		} else {
-			log.Info("-> [OK] 成功从 qBittorrent 移除任务。")
+		if !ok {
+			return taskBusy(h)
 		}
 	}
-	log.Info("===== [Cleanup Mode] 巡检完毕 =====")
+	query := `UPDATE tasks SET upload_status = 'cleaning', message = '正在清理',
+		lease_owner = ?, heartbeat_at = CURRENT_TIMESTAMP WHERE info_hash = ? AND upload_status = 'success'`
+	res, err := database.DB.Exec(query, leaseOwner, infoHash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return taskBusy(infoHash)
+	}
 	return nil
 }
 
-// --- 数据库操作封装 ---
-func addTask(infoHash, torrentName string) error {
-	query := `INSERT OR IGNORE INTO tasks (info_hash, torrent_name) VALUES (?, ?)`
-	_, err := database.DB.Exec(query, infoHash, torrentName)
+// releaseCleanup 清理失败时将任务恢复为 'success'，下次巡检时重新处理。
+func releaseCleanup(infoHash, message string) error {
+	query := `UPDATE tasks SET upload_status = 'success', message = ?, lease_owner = NULL, heartbeat_at = NULL
+		WHERE info_hash = ? AND lease_owner = ?`
+	_, err := database.DB.Exec(query, message, infoHash, leaseOwner)
 	return err
 }
 
-func updateTaskStatus(infoHash, status, message string) error {
-	query := `UPDATE tasks SET upload_status = ?, message = ? WHERE info_hash = ?`
-	_, err := database.DB.Exec(query, status, message, infoHash)
+// markTaskFailed 记录一次上传失败。未超过最大尝试次数时标记为 'failed' 并按指数退避安排下次重试，
+// 否则标记为 'dead'，不再自动重试。
+func markTaskFailed(infoHash, message string) error {
+	var attempts int
+	err := database.DB.QueryRow(`UPDATE tasks SET attempts = attempts + 1 WHERE info_hash = ? RETURNING attempts`, infoHash).Scan(&attempts)
+	if err != nil {
+		return err
+	}
+
+	maxAttempts := config.Cfg.Retry.MaxAttempts
+	if attempts >= maxAttempts {
+		log.Errorf("-> 已失败 %d 次，达到最大尝试次数，任务标记为 'dead'，不再自动重试。", attempts)
+		_, err = database.DB.Exec(`UPDATE tasks SET upload_status = 'dead', message = ?, next_retry_at = NULL WHERE info_hash = ?`, message, infoHash)
+		return err
+	}
+
+	delay := retryDelay(attempts)
+	log.Warnf("-> 第 %d/%d 次尝试失败，将在 %v 后自动重试。", attempts, maxAttempts, delay)
+	query := `UPDATE tasks SET upload_status = 'failed', message = ?, next_retry_at = datetime('now', ?) WHERE info_hash = ?`
+	_, err = database.DB.Exec(query, message, fmt.Sprintf("+%d seconds", int64(delay/time.Second)), infoHash)
+	return err
+}
+
+// markTaskInterrupted 将因程序退出而中断的任务标记为 'interrupted'。它不计入失败次数，
+// 下次运行时会和 'pending' 任务一样被重新领取，已经上传成功的文件不会重复上传。
+func markTaskInterrupted(infoHash string) error {
+	log.Warnf("-> 上传被中断，任务标记为 'interrupted'，将在下次运行时继续。")
+	query := `UPDATE tasks SET upload_status = 'interrupted', message = '上传被中断，将在下次运行时继续', next_retry_at = NULL
+		WHERE info_hash = ?`
+	_, err := database.DB.Exec(query, infoHash)
+	return err
+}
+
+// retryDelay 计算第 attempts 次失败后的等待时间: Backoff_Base 翻倍增长，不超过 Backoff_Max。
+func retryDelay(attempts int) time.Duration {
+	delay := config.Cfg.Retry.BackoffBase
+	for i := 1; i < attempts && delay < config.Cfg.Retry.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, config.Cfg.Retry.BackoffMax)
+}
+
+func updateDestinationStatus(infoHash, backend, status, message string) error {
+	query := `INSERT INTO task_destinations (info_hash, backend, status, message) VALUES (?, ?, ?, ?)
+		ON CONFLICT(info_hash, backend) DO UPDATE SET status = excluded.status, message = excluded.message, updated_at = CURRENT_TIMESTAMP`
+	_, err := database.DB.Exec(query, infoHash, backend, status, message)
 	return err
 }
 
+func getDestinationStatuses(infoHash string) (map[string]string, error) {
+	query := `SELECT backend, status FROM task_destinations WHERE info_hash = ?`
+	rows, err := database.DB.Query(query, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var backend, status string
+		if err := rows.Scan(&backend, &status); err != nil {
+			return nil, err
+		}
+		statuses[backend] = status
+	}
+	return statuses, rows.Err()
+}
+
 func getTaskByHash(infoHash string) (*database.Task, error) {
-	query := `SELECT info_hash, torrent_name, upload_status, message, created_at, updated_at FROM tasks WHERE info_hash = ?`
+	query := `SELECT info_hash, torrent_name, upload_status, message, local_path, remote_path, backend, size_bytes, file_count,
+		trash_path, created_at, updated_at FROM tasks WHERE info_hash = ?`
 	row := database.DB.QueryRow(query, infoHash)
 	var t database.Task
-	err := row.Scan(&t.InfoHash, &t.TorrentName, &t.UploadStatus, &t.Message, &t.CreatedAt, &t.UpdatedAt)
+	err := row.Scan(&t.InfoHash, &t.TorrentName, &t.UploadStatus, &t.Message, &t.LocalPath, &t.RemotePath,
+		&t.Backend, &t.SizeBytes, &t.FileCount, &t.TrashPath, &t.CreatedAt, &t.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
+// recordUploadTarget 记录任务本次上传的本地路径、上传目录、使用的后端以及内容的大小和文件数，
+// 清理时直接使用这些记录，不再根据当前配置重新推算。
+func recordUploadTarget(infoHash, localPath, remotePath string, backends []string, sizeBytes int64, fileCount int) error {
+	query := `UPDATE tasks SET local_path = ?, remote_path = ?, backend = ?, size_bytes = ?, file_count = ? WHERE info_hash = ?`
+	_, err := database.DB.Exec(query, localPath, remotePath, strings.Join(backends, ","), sizeBytes, fileCount, infoHash)
+	return err
+}
+
 func getTasksByStatus(status string) (map[string]bool, error) {
 	query := `SELECT info_hash FROM tasks WHERE upload_status = ?`
 	rows, err := database.DB.Query(query, status)
@@ -182,19 +431,51 @@ func getTasksByStatus(status string) (map[string]bool, error) {
 	return hashes, nil
 }
 
+// getKnownHashes 返回数据库中已登记的所有任务（无论状态）。
+func getKnownHashes() (map[string]bool, error) {
+	rows, err := database.DB.Query(`SELECT info_hash FROM tasks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var infoHash string
+		if err := rows.Scan(&infoHash); err != nil {
+			return nil, err
+		}
+		hashes[infoHash] = true
+	}
+	return hashes, rows.Err()
+}
+
+// pruneOldTasks 精简归档超过 DB_Keep_Archived_Days 天的任务：删除各目的地和各文件的上传记录，
+// 只保留 InfoHash、任务名、本地路径和归档状态。保留这条记录是为了让 watch 知道任务已经处理过，
+// 不会把仍在 qB 中做种的任务重新加入上传队列。
 func pruneOldTasks() (int64, error) {
 	days := config.Cfg.Maintenance.DBKeepArchivedDays
 	if days <= 0 {
 		return 0, nil
 	}
-	query := `DELETE FROM tasks WHERE upload_status = 'archived' AND updated_at < date('now', '-' || ? || ' day')`
+	query := `UPDATE tasks SET message = NULL, remote_path = NULL, backend = NULL, size_bytes = NULL, file_count = NULL
+		WHERE upload_status = 'archived' AND message IS NOT NULL AND updated_at < date('now', '-' || ? || ' day')`
 	result, err := database.DB.Exec(query, days)
 	if err != nil {
 		return 0, err
 	}
+	if _, err := database.DB.Exec(`DELETE FROM task_destinations WHERE info_hash IN (SELECT info_hash FROM tasks WHERE upload_status = 'archived' AND message IS NULL)`); err != nil {
+		return 0, err
+	}
+	if _, err := database.DB.Exec(`DELETE FROM task_files WHERE info_hash IN (SELECT info_hash FROM tasks WHERE upload_status = 'archived' AND message IS NULL)`); err != nil {
+		return 0, err
+	}
 	return result.RowsAffected()
 }
 
 func archiveTask(infoHash string) error {
-	return updateTaskStatus(infoHash, "archived", "任务已完成并归档")
+	query := `UPDATE tasks SET upload_status = 'archived', message = '任务已完成并归档', lease_owner = NULL, heartbeat_at = NULL
+		WHERE info_hash = ?`
+	_, err := database.DB.Exec(query, infoHash)
+	return err
 }
@@ -0,0 +1,213 @@
+package scheduler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/database"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// useTestDB 在临时目录中创建一个升级到最新版本的数据库，并设置一份空的配置。
+func useTestDB(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	config.Cfg = new(config.Config)
+	if err := database.Init(); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Close()
+		database.DB = nil
+	})
+	return dir
+}
+
+// exec 执行一条 SQL，用于准备测试数据。
+func exec(t *testing.T, query string, args ...any) {
+	t.Helper()
+	if _, err := database.DB.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// taskStatus 返回任务的 upload_status。
+func taskStatus(t *testing.T, infoHash string) string {
+	t.Helper()
+	var status string
+	if err := database.DB.QueryRow(`SELECT upload_status FROM tasks WHERE info_hash = ?`, infoHash).Scan(&status); err != nil {
+		t.Fatalf("读取任务 '%s' 的状态失败: %v", infoHash, err)
+	}
+	return status
+}
+
+// fakeQB 模拟 qBittorrent 的 Web API：返回预先设置的任务和 Tracker 列表，并记录收到的其他请求。
+type fakeQB struct {
+	torrents []qbittorrent.Torrent
+	trackers map[string][]string // InfoHash -> Tracker 地址
+
+	mu    sync.Mutex
+	calls []string // 除登录和查询以外的请求，格式为 "接口 参数hashes"
+	count map[string]int
+}
+
+// newFakeQB 启动模拟的 qB，并把 config.Cfg 中的 qB 地址指向它。
+func newFakeQB(t *testing.T, torrents ...qbittorrent.Torrent) *fakeQB {
+	t.Helper()
+	f := &fakeQB{torrents: torrents, trackers: make(map[string][]string), count: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	config.Cfg.QBittorrent.Host = srv.URL
+	return f
+}
+
+func (f *fakeQB) serve(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count[endpoint]++
+	switch endpoint {
+	case "auth/login":
+		w.Write([]byte("Ok."))
+	case "app/webapiVersion":
+		w.Write([]byte("2.11.2"))
+	case "torrents/info":
+		hashes := r.URL.Query().Get("hashes")
+		torrents := []qbittorrent.Torrent{}
+		for _, torrent := range f.torrents {
+			if hashes != "" && !strings.Contains("|"+hashes+"|", "|"+torrent.Hash+"|") {
+				continue
+			}
+			if r.URL.Query().Get("filter") == "completed" && torrent.Progress < 1 {
+				continue
+			}
+			torrents = append(torrents, torrent)
+		}
+		json.NewEncoder(w).Encode(torrents)
+	case "torrents/trackers":
+		trackers := []qbittorrent.TorrentTracker{}
+		for _, u := range f.trackers[r.URL.Query().Get("hash")] {
+			trackers = append(trackers, qbittorrent.TorrentTracker{Url: u})
+		}
+		json.NewEncoder(w).Encode(trackers)
+	default:
+		r.ParseForm()
+		f.calls = append(f.calls, endpoint+" "+r.PostForm.Get("hashes"))
+	}
+}
+
+// requests 返回并清空记录的请求。
+func (f *fakeQB) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// called 返回某个接口被调用的次数。
+func (f *fakeQB) called(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count[endpoint]
+}
+
+// client 返回已登录模拟 qB 的客户端。
+func (f *fakeQB) client(t *testing.T) *qbittorrent.Client {
+	t.Helper()
+	qbClient, err := newQBClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qbClient
+}
+
+func TestRetryDelay(t *testing.T) {
+	config.Cfg = new(config.Config)
+	config.Cfg.Retry.BackoffBase = time.Minute
+	config.Cfg.Retry.BackoffMax = time.Hour
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour}, // 64 分钟超过上限
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v，应为 %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMarkTaskFailed(t *testing.T) {
+	useTestDB(t)
+	config.Cfg.Retry.MaxAttempts = 3
+	config.Cfg.Retry.BackoffBase = time.Minute
+	config.Cfg.Retry.BackoffMax = time.Hour
+	if err := enqueueTask("abc", "Show", "/downloads/Show"); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt < 3; attempt++ {
+		task, err := claimPendingTask()
+		if err != nil {
+			t.Fatalf("第 %d 次领取任务失败: %v", attempt, err)
+		}
+		if err := markTaskFailed(task.InfoHash, "网络错误"); err != nil {
+			t.Fatal(err)
+		}
+		if status := taskStatus(t, "abc"); status != "failed" {
+			t.Fatalf("第 %d 次失败后状态为 %q，应为 failed", attempt, status)
+		}
+		// 按指数退避安排下次重试，未到时间时不会被领取
+		var wait int64
+		database.DB.QueryRow(`SELECT CAST(strftime('%s', next_retry_at) AS INTEGER) - CAST(strftime('%s', 'now') AS INTEGER) FROM tasks WHERE info_hash = 'abc'`).Scan(&wait)
+		if want := int64(retryDelay(attempt) / time.Second); wait < want-5 || wait > want {
+			t.Errorf("第 %d 次失败后 %d 秒后重试，应为 %d 秒", attempt, wait, want)
+		}
+		if _, err := claimPendingTask(); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("未到重试时间的任务被领取了: %v", err)
+		}
+		exec(t, `UPDATE tasks SET next_retry_at = datetime('now', '-1 seconds') WHERE info_hash = 'abc'`)
+	}
+
+	task, err := claimPendingTask()
+	if err != nil {
+		t.Fatalf("到了重试时间的任务没有被领取: %v", err)
+	}
+	if err := markTaskFailed(task.InfoHash, "网络错误"); err != nil {
+		t.Fatal(err)
+	}
+	// 达到最大尝试次数后不再自动重试
+	if status := taskStatus(t, "abc"); status != "dead" {
+		t.Errorf("达到最大尝试次数后状态为 %q，应为 dead", status)
+	}
+	if _, err := claimPendingTask(); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("dead 任务被领取了: %v", err)
+	}
+
+	// qB 再次触发回调视为手动重试，重置尝试次数
+	if err := addTask("abc", "Show", "/downloads/Show"); err != nil {
+		t.Fatal(err)
+	}
+	var attempts int
+	database.DB.QueryRow(`SELECT attempts FROM tasks WHERE info_hash = 'abc'`).Scan(&attempts)
+	if attempts != 0 {
+		t.Errorf("手动重试后尝试次数为 %d，应为 0", attempts)
+	}
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// torrentContentPath 返回 qB 任务的内容路径。
+func torrentContentPath(t qbittorrent.Torrent) string {
+	if t.ContentPath != "" {
+		return t.ContentPath
+	}
+	return filepath.Join(t.SavePath, t.Name)
+}
+
+// sharingTorrents 找出与 contentPath 共用内容的其他任务：内容路径相同，或者一方位于另一方的目录之内。
+func sharingTorrents(all []qbittorrent.Torrent, infoHash, contentPath string) []qbittorrent.Torrent {
+	contentPath = filepath.Clean(contentPath)
+	var sharing []qbittorrent.Torrent
+	for _, other := range all {
+		if other.Hash == infoHash {
+			continue
+		}
+		if pathsOverlap(contentPath, filepath.Clean(torrentContentPath(other))) {
+			sharing = append(sharing, other)
+		}
+	}
+	return sharing
+}
+
+func pathsOverlap(a, b string) bool {
+	sep := string(filepath.Separator)
+	return a == b || strings.HasPrefix(a, b+sep) || strings.HasPrefix(b, a+sep)
+}
+
+// crossSeedBlocked 按 Cross_Seed_Policy 判断共用内容的任务是否可以一起清理，不能清理时返回原因。
+// eligible 是本次满足清理条件的任务。
+func crossSeedBlocked(sharing []qbittorrent.Torrent, eligible map[string]taskPolicy) string {
+	names := make([]string, 0, len(sharing))
+	for _, other := range sharing {
+		names = append(names, other.Name)
+	}
+	log.Infof("    -> 内容同时被 %d 个其他任务使用 (辅种): %s", len(sharing), strings.Join(names, ", "))
+
+	switch config.Cfg.SeedingPolicy.CrossSeedPolicy {
+	case "wait_all":
+		for _, other := range sharing {
+			if _, ok := eligible[other.Hash]; !ok {
+				log.Infof("    -> 任务 '%s' 尚未满足清理条件，按照 Cross_Seed_Policy = wait_all 的设置暂不清理。", other.Name)
+				return fmt.Sprintf("共用内容的任务 '%s' 尚未满足清理条件", other.Name)
+			}
+		}
+		return ""
+	case "together":
+		for _, other := range sharing {
+			if other.Progress < 1 {
+				log.Warnf("    -> 任务 '%s' 仍在下载，暂不清理共用的内容。", other.Name)
+				return fmt.Sprintf("共用内容的任务 '%s' 仍在下载", other.Name)
+			}
+		}
+		return ""
+	default:
+		log.Infof("    -> 按照 Cross_Seed_Policy = skip 的设置，跳过此任务。")
+		return "内容被其他任务共用 (Cross_Seed_Policy = skip)"
+	}
+}
+
+// verifySharing 检查随本任务一起处理的共用内容任务。它们会被一起归档并执行 qB 操作，
+// 因此必须内容路径完全相同、Action_After_Process 与本任务的 action 相同、已上传成功，
+// 并且各自的上传记录也能校验通过；不满足时返回原因。
+func verifySharing(ctx context.Context, qbClient *qbittorrent.Client, sharing []qbittorrent.Torrent, contentPath string, action afterAction, uploaded map[string]bool,
+	backendsFor func([]string) ([]storage.Backend, error), record bool) string {
+	for _, other := range sharing {
+		if filepath.Clean(torrentContentPath(other)) != filepath.Clean(contentPath) {
+			log.Infof("    -> 任务 '%s' 的内容路径与本任务不完全相同，暂不清理共用的内容。", other.Name)
+			return fmt.Sprintf("共用内容的任务 '%s' 的内容路径与本任务不完全相同", other.Name)
+		}
+		if !uploaded[other.Hash] {
+			log.Infof("    -> 任务 '%s' 尚未上传成功，暂不清理共用的内容。", other.Name)
+			return fmt.Sprintf("共用内容的任务 '%s' 尚未上传成功", other.Name)
+		}
+		pol := policyFor(qbClient, other)
+		if pol.action != action {
+			// 同一组任务只执行一种 qB 操作、一起处理本地内容，处理方式不同时无法一起清理
+			log.Infof("    -> 任务 '%s' 的处理方式 (%s) 与本任务 (%s) 不同，暂不清理共用的内容。", other.Name, pol.action, action)
+			return fmt.Sprintf("共用内容的任务 '%s' 的处理方式 (%s) 与本任务不同", other.Name, pol.action)
+		}
+		task, err := getTaskByHash(other.Hash)
+		if err != nil {
+			log.Errorf("    -> 读取任务 '%s' 的上传记录失败: %v", other.Name, err)
+			return fmt.Sprintf("读取共用内容的任务 '%s' 的上传记录失败: %v", other.Name, err)
+		}
+		target := cleanupTarget(task, other, pol)
+		backends, err := backendsFor(target.backends)
+		if err != nil {
+			log.Errorf("    -> 初始化存储后端失败: %v", err)
+			return fmt.Sprintf("初始化共用内容的任务 '%s' 的存储后端失败: %v", other.Name, err)
+		}
+		log.Infof("    -> 正在校验共用内容的任务 '%s'...", other.Name)
+		if verified := verifyAll(ctx, backends, other.Hash, target.localPath, target.remoteDir, record); verified < target.quorum {
+			log.Errorf("    -> 任务 '%s' 仅 %d/%d 个目的地校验通过，暂不清理共用的内容。", other.Name, verified, len(backends))
+			return fmt.Sprintf("共用内容的任务 '%s' 仅 %d/%d 个目的地校验通过", other.Name, verified, len(backends))
+		}
+	}
+	return ""
+}
+
+// checkHardlinks 按 Hardlink_Policy 判断存在硬链接的内容如何处理。
+// skipReason 不为空表示跳过该任务，keepFiles 为 true 表示照常处理但保留本地文件。
+func checkHardlinks(contentPath string) (keepFiles bool, skipReason string) {
+	if config.Cfg.SeedingPolicy.HardlinkPolicy == "delete" {
+		return false, ""
+	}
+	linked, supported, err := storage.HardLinkedFiles(contentPath)
+	if err != nil {
+		log.Errorf("    -> 检查硬链接失败: %v。跳过此任务。", err)
+		return false, fmt.Sprintf("检查硬链接失败: %v", err)
+	}
+	if !supported {
+		log.Debugf("    -> 当前平台无法检查硬链接数量。")
+	}
+	if len(linked) == 0 {
+		return false, ""
+	}
+	log.Infof("    -> %d 个文件存在其他硬链接 (例如媒体库)，如: %s", len(linked), linked[0])
+	if config.Cfg.SeedingPolicy.HardlinkPolicy == "keep_files" {
+		log.Infof("    -> 按照 Hardlink_Policy = keep_files 的设置，保留本地文件。")
+		return true, ""
+	}
+	log.Infof("    -> 按照 Hardlink_Policy = skip 的设置，跳过此任务。")
+	return false, fmt.Sprintf("%d 个文件存在其他硬链接 (Hardlink_Policy = skip)", len(linked))
+}
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+func TestVerifySharingRequiresSameAction(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	config.Cfg.SeedingPolicy.ActionAfterProcess = config.ActionDelete
+	config.Cfg.Uploader.Backends = []string{"nas"}
+	config.Cfg.Uploader.Quorum = 1
+	config.Cfg.Rules = []config.Rule{{Name: "pt", Tags: []string{"pt"}, Action: config.ActionPause}}
+
+	nas := newMemBackend("nas")
+	if err := nas.Upload(context.Background(), content, "/tv"); err != nil {
+		t.Fatal(err)
+	}
+	backendsFor := func([]string) ([]storage.Backend, error) { return []storage.Backend{nas}, nil }
+	for _, h := range []string{"aaa", "bbb"} {
+		if err := addTask(h, "Show", content); err != nil {
+			t.Fatal(err)
+		}
+		exec(t, `UPDATE tasks SET upload_status = 'success', remote_path = '/tv' WHERE info_hash = ?`, h)
+	}
+	uploaded := map[string]bool{"aaa": true, "bbb": true}
+	public := qbittorrent.Torrent{Hash: "aaa", Name: "Show", ContentPath: content}
+	pt := qbittorrent.Torrent{Hash: "bbb", Name: "Show", ContentPath: content, Tags: "pt"}
+	qb := newFakeQB(t, public, pt)
+	qbClient := qb.client(t)
+
+	// 共用内容的任务按规则需要暂停而不是删除，不能随本任务一起删除
+	reason := verifySharing(context.Background(), qbClient, []qbittorrent.Torrent{pt}, content, policyFor(qbClient, public).action, uploaded, backendsFor, false)
+	if !strings.Contains(reason, "处理方式") {
+		t.Errorf("处理方式不同时的原因为 %q", reason)
+	}
+
+	// 处理方式相同时可以一起清理
+	pt.Tags = ""
+	if reason := verifySharing(context.Background(), qbClient, []qbittorrent.Torrent{pt}, content, policyFor(qbClient, public).action, uploaded, backendsFor, false); reason != "" {
+		t.Errorf("处理方式相同时被跳过: %s", reason)
+	}
+}
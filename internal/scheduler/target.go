@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"strings"
+
+	"qbuploader/internal/database"
+	"qbuploader/internal/storage"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// uploadTarget 是清理时需要校验和删除的位置。
+type uploadTarget struct {
+	localPath string
+	remoteDir string
+	backends  []string
+	quorum    int
+}
+
+// cleanupTarget 优先使用上传时记录在数据库中的位置和后端；
+// 旧版本登记的任务缺少这些记录时，才根据 qB 中的信息和当前配置推算。
+func cleanupTarget(task *database.Task, t qbittorrent.Torrent, pol taskPolicy) uploadTarget {
+	target := uploadTarget{
+		localPath: task.LocalPath.String,
+		remoteDir: task.RemotePath.String,
+		backends:  pol.backends,
+		quorum:    pol.quorum,
+	}
+	if target.localPath == "" {
+		target.localPath = torrentContentPath(t)
+	}
+	if target.remoteDir == "" {
+		target.remoteDir = storage.JoinRemote(pol.remoteRoot, t.Name)
+	}
+	if task.Backend.String != "" {
+		target.backends = strings.Split(task.Backend.String, ",")
+		// 配置中的成功条件可能是按更多的后端计算的，不能超过上传时实际使用的后端数量
+		target.quorum = min(pol.quorum, len(target.backends))
+	}
+	return target
+}
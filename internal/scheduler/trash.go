@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/database"
+)
+
+// removeLocal 清理本地内容。启用回收站时移动到回收站，否则直接删除。
+// hashes 是共用这份内容的所有任务，第一个是本任务；移入回收站时为每个任务都记录回收站中的位置。
+func removeLocal(hashes []string, contentPath string) error {
+	if !config.Cfg.Trash.Enabled {
+		log.Infof("    -> 正在删除本地文件: %s", contentPath)
+		if err := os.RemoveAll(contentPath); err != nil {
+			return err
+		}
+		log.Infof("    -> [OK] 本地文件已删除。")
+		return nil
+	}
+
+	// 每个任务在回收站中占一个以 InfoHash 命名的目录，避免同名内容互相覆盖
+	dir := filepath.Join(config.Cfg.Trash.Dir, hashes[0])
+	trashPath := filepath.Join(dir, filepath.Base(contentPath))
+	log.Infof("    -> 正在将本地文件移入回收站: %s -> %s", contentPath, trashPath)
+	if _, err := os.Lstat(trashPath); err == nil {
+		return fmt.Errorf("回收站中已存在 '%s'，请先处理", trashPath)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("创建回收站目录失败: %w", err)
+	}
+	if err := os.Rename(contentPath, trashPath); err != nil {
+		os.Remove(dir) // 仅在目录为空时生效
+		if errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("回收站目录与下载目录不在同一个文件系统，无法移动: %w", err)
+		}
+		return fmt.Errorf("移入回收站失败: %w", err)
+	}
+	// 目录的修改时间即为移入回收站的时间，清空回收站时以它为准
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+
+	query := `UPDATE tasks SET trash_path = ?, trashed_at = CURRENT_TIMESTAMP WHERE info_hash = ?`
+	for _, h := range hashes {
+		if _, err := database.DB.Exec(query, trashPath, h); err != nil {
+			log.Warnf("    -> 记录任务 '%s' 的回收站位置失败: %v", h, err)
+		}
+	}
+	log.Infof("    -> [OK] 本地文件已移入回收站。")
+	return nil
+}
+
+// purgeTrash 彻底删除回收站中超过保留期限的内容。
+func purgeTrash() {
+	retention := config.Cfg.Trash.Retention
+	if retention <= 0 || config.Cfg.Trash.Dir == "" {
+		return
+	}
+	entries, err := os.ReadDir(config.Cfg.Trash.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Warnf("-> 读取回收站目录失败: %v", err)
+		return
+	}
+	cutoff := time.Now().Add(-retention)
+	purged := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		p := filepath.Join(config.Cfg.Trash.Dir, entry.Name())
+		if err := os.RemoveAll(p); err != nil {
+			log.Warnf("-> 清空回收站中的 '%s' 失败: %v", p, err)
+			continue
+		}
+		// 目录以第一个任务的 InfoHash 命名，共用这份内容的其他任务记录的是同一个位置
+		query := `UPDATE tasks SET trash_path = NULL, trashed_at = NULL
+			WHERE trash_path IN (SELECT trash_path FROM tasks WHERE info_hash = ? AND trash_path IS NOT NULL)`
+		if _, err := database.DB.Exec(query, entry.Name()); err != nil {
+			log.Warnf("-> 更新任务 '%s' 的回收站记录失败: %v", entry.Name(), err)
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Infof("-> [OK] 已彻底删除回收站中 %d 个超过 %d 天的任务。", purged, int(retention.Hours()/24))
+	}
+}
+
+// RunRestoreLocal 将回收站中的任务内容移回原来的位置，并让 qB 重新校验该任务。
+// 与它共用这份内容、一起移入回收站的任务也会一起恢复，恢复后的任务重新按已上传的任务参与清理。
+func RunRestoreLocal(infoHash string) error {
+	log.Infof("===== [Restore Mode] 任务: %s =====", infoHash)
+	task, err := getTaskByHash(infoHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("数据库中没有 InfoHash 为 '%s' 的任务", infoHash)
+	}
+	if err != nil {
+		return fmt.Errorf("查询数据库失败: %w", err)
+	}
+	trashPath, localPath := task.TrashPath.String, task.LocalPath.String
+	if trashPath == "" {
+		return fmt.Errorf("任务 '%s' 不在回收站中（可能未启用回收站，或已超过保留期限被彻底删除）", task.TorrentName)
+	}
+	if localPath == "" {
+		return fmt.Errorf("任务 '%s' 没有记录原始位置，无法恢复", task.TorrentName)
+	}
+	if _, err := os.Lstat(localPath); err == nil {
+		return fmt.Errorf("原始位置 '%s' 已存在，为避免覆盖，请先手动处理", localPath)
+	}
+
+	log.Infof("-> 正在恢复: %s -> %s", trashPath, localPath)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+	if err := os.Rename(trashPath, localPath); err != nil {
+		return fmt.Errorf("移动文件失败: %w", err)
+	}
+	os.Remove(filepath.Dir(trashPath)) // 回收站中以 InfoHash 命名的目录此时应为空
+	hashes, err := trashedWith(trashPath)
+	if err != nil {
+		log.Warnf("-> 查询共用这份内容的任务失败: %v", err)
+	}
+	if len(hashes) == 0 {
+		hashes = []string{infoHash}
+	}
+	query := `UPDATE tasks SET upload_status = 'success', message = '已从回收站恢复', trash_path = NULL, trashed_at = NULL WHERE info_hash = ?`
+	for _, h := range hashes {
+		if _, err := database.DB.Exec(query, h); err != nil {
+			log.Warnf("-> 更新任务 '%s' 的状态失败: %v", h, err)
+		}
+	}
+	log.Info("-> [OK] 本地文件已恢复。")
+	if len(hashes) > 1 {
+		log.Infof("-> 共用这份内容的 %d 个任务已一起恢复。", len(hashes))
+	}
+
+	qbClient, err := newQBClient()
+	if err != nil {
+		return fmt.Errorf("文件已恢复，但%w，请手动在 qB 中重新校验该任务", err)
+	}
+	if err := qbClient.Recheck(hashes); err != nil {
+		return fmt.Errorf("文件已恢复，但让 qB 重新校验失败: %w。如果任务已从 qB 中删除，请重新添加种子并指向 '%s'", err, filepath.Dir(localPath))
+	}
+	log.Info("-> [OK] 已通知 qBittorrent 重新校验该任务。")
+	log.Info("===== [Restore Mode] 执行完毕 =====")
+	return nil
+}
+
+// trashedWith 返回回收站位置为 trashPath 的所有任务。
+func trashedWith(trashPath string) ([]string, error) {
+	rows, err := database.DB.Query(`SELECT info_hash FROM tasks WHERE trash_path = ?`, trashPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+)
+
+func TestTrashAndRestoreSharedContent(t *testing.T) {
+	dir := useTestDB(t)
+	qb := newFakeQB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	for _, h := range []string{"aaa", "bbb", "ccc"} {
+		exec(t, `INSERT INTO tasks (info_hash, torrent_name, local_path, upload_status) VALUES (?, 'Show', ?, 'archived')`, h, content)
+	}
+	trash := useTrash(t)
+
+	// aaa 与 bbb 共用同一份内容 (辅种)，ccc 与它们无关
+	if err := removeLocal([]string{"aaa", "bbb"}, content); err != nil {
+		t.Fatalf("removeLocal: %v", err)
+	}
+	trashPath := filepath.Join(trash, "aaa", "Show")
+	if _, err := os.Stat(filepath.Join(trashPath, "ep1.mkv")); err != nil {
+		t.Fatalf("回收站中没有内容: %v", err)
+	}
+	for _, h := range []string{"aaa", "bbb"} {
+		task, err := getTaskByHash(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.TrashPath.String != trashPath {
+			t.Errorf("任务 %s 记录的回收站位置为 %q，应为 %q", h, task.TrashPath.String, trashPath)
+		}
+	}
+	if task, _ := getTaskByHash("ccc"); task.TrashPath.Valid {
+		t.Errorf("无关的任务 ccc 也记录了回收站位置 %q", task.TrashPath.String)
+	}
+
+	// 从任意一个任务恢复，共用内容的任务一起恢复为已上传，并一起让 qB 重新校验
+	if err := RunRestoreLocal("bbb"); err != nil {
+		t.Fatalf("RunRestoreLocal: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(content, "ep1.mkv")); err != nil {
+		t.Errorf("内容没有恢复到原位置: %v", err)
+	}
+	for _, h := range []string{"aaa", "bbb"} {
+		if status := taskStatus(t, h); status != "success" {
+			t.Errorf("任务 %s 恢复后的状态为 %q，应为 success", h, status)
+		}
+		if task, _ := getTaskByHash(h); task.TrashPath.Valid {
+			t.Errorf("任务 %s 恢复后仍记录了回收站位置 %q", h, task.TrashPath.String)
+		}
+	}
+	if status := taskStatus(t, "ccc"); status != "archived" {
+		t.Errorf("无关的任务 ccc 的状态变为 %q", status)
+	}
+	if got := qb.requests(); !slices.Equal(got, []string{"torrents/recheck aaa|bbb"}) {
+		t.Errorf("qB 收到的请求为 %q", got)
+	}
+}
+
+func TestPurgeTrashSharedContent(t *testing.T) {
+	dir := useTestDB(t)
+	content := filepath.Join(dir, "downloads", "Show")
+	writeTestFile(t, filepath.Join(content, "ep1.mkv"), "episode one")
+	for _, h := range []string{"aaa", "bbb"} {
+		exec(t, `INSERT INTO tasks (info_hash, torrent_name, local_path, upload_status) VALUES (?, 'Show', ?, 'archived')`, h, content)
+	}
+	trash := useTrash(t)
+	if err := removeLocal([]string{"aaa", "bbb"}, content); err != nil {
+		t.Fatalf("removeLocal: %v", err)
+	}
+
+	purgeTrash()
+	if _, err := os.Stat(filepath.Join(trash, "aaa")); !os.IsNotExist(err) {
+		t.Fatalf("过期的内容没有被删除: %v", err)
+	}
+	for _, h := range []string{"aaa", "bbb"} {
+		if task, _ := getTaskByHash(h); task.TrashPath.Valid {
+			t.Errorf("任务 %s 的回收站位置没有被清除", h)
+		}
+	}
+}
+
+// useTrash 启用回收站，返回回收站目录。
+func useTrash(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "trash")
+	config.Cfg.Trash.Enabled = true
+	config.Cfg.Trash.Dir = dir
+	config.Cfg.Trash.Retention = time.Nanosecond // 在测试中相当于立即过期
+	return dir
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
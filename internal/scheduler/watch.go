@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"qbuploader/internal/config"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+// RunWatchMode 轮询 qBittorrent，把已经下载完成但尚未登记的任务加入上传队列，
+// 作为 "任务完成时" 回调的补充：回调配置错误、执行失败，或者任务在 qbuploader 未运行期间完成时，
+// 这些任务仍然会被上传。
+//
+// 已启用守护进程模式时只负责登记，上传交给守护进程；否则登记后由本进程依次上传。
+// once 为 true 时只扫描一次就退出，适合交给任务计划程序定时调用。
+func RunWatchMode(ctx context.Context, once bool) error {
+	release, err := acquireLock(lockWatch)
+	if errors.Is(err, errLocked) {
+		log.Warnf("-> 另一个监视进程正在运行，本次退出: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	cfg := config.Cfg.Watch
+	log.Infof("===== [Watch Mode] 开始监视 qBittorrent，轮询间隔: %v =====", cfg.Interval)
+
+	qbClient, err := newQBClient()
+	if err != nil {
+		return err
+	}
+	for {
+		if err := scanCompleted(qbClient, cfg.GracePeriod); err != nil {
+			if once {
+				return err
+			}
+			log.Errorf("-> 扫描 qBittorrent 失败: %v", err)
+		}
+		if !config.Cfg.Daemon.Enabled {
+			drainQueue(ctx)
+		}
+		if once {
+			log.Info("===== [Watch Mode] 执行完毕 =====")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.Interval):
+		}
+	}
+}
+
+// runWatchLoop 在守护进程中定时扫描 qBittorrent，只负责登记，上传由工作线程完成。
+func runWatchLoop(ctx context.Context, interval, gracePeriod time.Duration) {
+	var qbClient *qbittorrent.Client
+	for {
+		if qbClient == nil {
+			client, err := newQBClient()
+			if err != nil {
+				log.Errorf("-> [Watch] %v", err)
+			} else {
+				qbClient = client
+			}
+		}
+		if qbClient != nil {
+			if err := scanCompleted(qbClient, gracePeriod); err != nil {
+				log.Errorf("-> [Watch] 扫描 qBittorrent 失败，下次将重新登录: %v", err)
+				qbClient = nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// scanCompleted 找出已经下载完成、完成时间超过 gracePeriod 且不在 tasks 表中的任务，并登记为 'pending'。
+// 留出 gracePeriod 是为了让 "任务完成时" 回调优先处理，避免同一个任务被重复登记。
+func scanCompleted(qbClient *qbittorrent.Client, gracePeriod time.Duration) error {
+	torrents, err := qbClient.GetTorrents(qbittorrent.TorrentFilterOptions{Filter: qbittorrent.TorrentFilterCompleted})
+	if err != nil {
+		return fmt.Errorf("获取 qB 任务列表失败: %w", err)
+	}
+	known, err := getKnownHashes()
+	if err != nil {
+		return fmt.Errorf("从数据库获取任务列表失败: %w", err)
+	}
+
+	cutoff := time.Now().Add(-gracePeriod).Unix()
+	added := 0
+	for _, t := range torrents {
+		if t.Progress < 1 || known[t.Hash] {
+			continue
+		}
+		if t.CompletionOn > cutoff {
+			log.Debugf("  -> 任务 '%s' 刚刚完成，等待回调处理。", t.Name)
+			continue
+		}
+		if pol := policyFor(qbClient, t); pol.skipUpload {
+			log.Debugf("  -> 任务 '%s' 匹配 %s，设置为不上传。", t.Name, pol)
+			continue
+		}
+		contentPath := t.ContentPath
+		if contentPath == "" {
+			contentPath = filepath.Join(t.SavePath, t.Name)
+		}
+		// 本地内容已经不在了（例如已清理、仅保留 qB 中的任务），没有东西可以上传
+		if _, err := os.Stat(contentPath); errors.Is(err, os.ErrNotExist) {
+			log.Debugf("  -> 任务 '%s' 的本地内容 '%s' 不存在，跳过。", t.Name, contentPath)
+			continue
+		}
+		if err := enqueueTask(t.Hash, t.Name, contentPath); err != nil {
+			log.Errorf("  -> 登记任务 '%s' 失败: %v", t.Name, err)
+			continue
+		}
+		log.Infof("  -> 发现未登记的已完成任务 '%s'，已加入上传队列。", t.Name)
+		added++
+	}
+	if added > 0 {
+		log.Infof("-> [OK] 本次共登记 %d 个新任务。", added)
+	} else {
+		log.Debug("-> 没有发现新的已完成任务。")
+	}
+	return nil
+}
+
+// drainQueue 在当前进程中依次处理所有 'pending' 任务，直到队列为空。
+func drainQueue(ctx context.Context) {
+	for ctx.Err() == nil {
+		task, err := claimPendingTask()
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Errorf("-> 领取上传任务失败: %v", err)
+			return
+		}
+		log.Infof("-> 开始上传: %s", task.TorrentName)
+		if err := processUpload(ctx, task.InfoHash, task.TorrentName, task.LocalPath.String); err != nil {
+			log.Errorf("-> %s: %v", task.TorrentName, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/database"
+
+	"github.com/autobrr/go-qbittorrent"
+)
+
+func TestScanCompleted(t *testing.T) {
+	dir := useTestDB(t)
+	config.Cfg.Maintenance.DBKeepArchivedDays = 30
+	completed := time.Now().Add(-time.Hour).Unix()
+	torrent := func(hash string) qbittorrent.Torrent {
+		content := filepath.Join(dir, "downloads", hash)
+		return qbittorrent.Torrent{Hash: hash, Name: hash, Progress: 1, CompletionOn: completed, ContentPath: content}
+	}
+	for _, h := range []string{"old", "new"} {
+		writeTestFile(t, filepath.Join(dir, "downloads", h, "ep1.mkv"), "episode")
+	}
+	qb := newFakeQB(t, torrent("old"), torrent("gone"), torrent("new"))
+
+	// old 一年前就已归档，记录会被精简，但不能因此被当作新任务
+	exec(t, `INSERT INTO tasks (info_hash, torrent_name, upload_status, message, remote_path, backend, updated_at)
+		VALUES ('old', 'old', 'archived', '任务已完成并归档', '/tv', 'local', datetime('now', '-365 day'))`)
+	exec(t, `INSERT INTO task_destinations (info_hash, backend, status) VALUES ('old', 'local', 'success')`)
+	exec(t, `INSERT INTO task_files (info_hash, backend, path, size_bytes, status) VALUES ('old', 'local', 'ep1.mkv', 7, 'success')`)
+	pruned, err := pruneOldTasks()
+	if err != nil {
+		t.Fatalf("pruneOldTasks: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("精简了 %d 条记录，应为 1 条", pruned)
+	}
+	var destinations, files int
+	database.DB.QueryRow(`SELECT COUNT(*) FROM task_destinations`).Scan(&destinations)
+	database.DB.QueryRow(`SELECT COUNT(*) FROM task_files`).Scan(&files)
+	if destinations != 0 || files != 0 {
+		t.Errorf("精简后仍有 %d 条目的地记录、%d 条文件记录", destinations, files)
+	}
+	if pruned, _ := pruneOldTasks(); pruned != 0 {
+		t.Errorf("再次精简时处理了 %d 条记录，应为 0 条", pruned)
+	}
+
+	if err := scanCompleted(qb.client(t), time.Minute); err != nil {
+		t.Fatalf("scanCompleted: %v", err)
+	}
+	if status := taskStatus(t, "old"); status != "archived" {
+		t.Errorf("已归档任务的状态变为 %q", status)
+	}
+	// gone 的本地内容已经不存在，没有东西可以上传
+	if _, err := getTaskByHash("gone"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("本地内容不存在的任务被登记了: %v", err)
+	}
+	if status := taskStatus(t, "new"); status != "pending" {
+		t.Errorf("新任务的状态为 %q，应为 pending", status)
+	}
+}
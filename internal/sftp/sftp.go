@@ -0,0 +1,349 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/logger"
+	"qbuploader/internal/storage"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Uploader 通过 SFTP 将内容推送到远程服务器（例如从 seedbox 推回家里的机器），
+// 实现了 storage.Backend 接口。每次操作都会建立一个新的 SSH 连接，操作结束后关闭。
+type Uploader struct {
+	addr      string
+	root      string
+	sshConfig *ssh.ClientConfig
+}
+
+var (
+	_ storage.Backend  = (*Uploader)(nil)
+	_ storage.Verifier = (*Uploader)(nil)
+)
+
+// NewUploader 根据 [SFTP] 配置创建一个新的 SFTP Uploader 实例。
+func NewUploader() (*Uploader, error) {
+	cfg := config.Cfg.SFTP
+	if cfg.Host == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("[SFTP] 中的 Host 和 Username 不能为空")
+	}
+
+	var auths []ssh.AuthMethod
+	if cfg.PrivateKey != "" {
+		keyBytes, err := os.ReadFile(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("读取 SSH 私钥失败: %w", err)
+		}
+		var signer ssh.Signer
+		if cfg.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(cfg.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(keyBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 SSH 私钥失败: %w", err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auths = append(auths, ssh.Password(cfg.Password))
+	}
+	if len(auths) == 0 {
+		return nil, fmt.Errorf("[SFTP] 中至少需要配置 Password 或 Private_Key 之一")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if cfg.InsecureIgnoreHostKey {
+		logger.Log.Warn("[SFTP] 已关闭主机密钥校验，连接可能遭受中间人攻击。")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		callback, err := knownhosts.New(cfg.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("读取 known_hosts 文件 '%s' 失败: %w", cfg.KnownHosts, err)
+		}
+		hostKeyCallback = callback
+	}
+
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return &Uploader{
+		addr: addr,
+		root: cfg.Root,
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            auths,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "sftp"
+}
+
+// Upload 递归上传文件或目录到 remoteDir 下。
+// 远程已存在的文件会按大小续传：比本地小则从断点处追加，一致则跳过，比本地大则重新上传。
+// 上传完成后保留原有的修改时间。
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
+	log := logger.Log
+
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	client, done, err := u.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	log.Infof("  -> 正在上传: %s -> sftp://%s%s", localPath, u.addr, u.serverPath(remoteDir))
+	for _, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dest := u.serverPath(storage.JoinRemote(remoteDir, lf.RelPath))
+		if err := client.MkdirAll(path.Dir(dest)); err != nil {
+			return fmt.Errorf("创建远程目录 '%s' 失败: %w", path.Dir(dest), err)
+		}
+		if err := putFile(client, lf, dest); err != nil {
+			return fmt.Errorf("上传 '%s' 失败: %w", lf.RelPath, err)
+		}
+	}
+	return nil
+}
+
+// Exists 判断远程路径是否存在。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验 SFTP 文件: %s", u.serverPath(remotePath))
+	_, err := u.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stat 获取远程路径信息。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	client, done, err := u.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	info, err := client.Stat(u.serverPath(remotePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toFileInfo(remotePath, info), nil
+}
+
+// Delete 递归删除远程文件或目录。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	client, done, err := u.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	err = client.RemoveAll(u.serverPath(remotePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List 列出远程目录下的直接子项。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	client, done, err := u.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	infos, err := client.ReadDir(u.serverPath(remoteDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", remoteDir, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]storage.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, *toFileInfo(storage.JoinRemote(remoteDir, info.Name()), info))
+	}
+	return entries, nil
+}
+
+// Verify 在同一个 SFTP 会话中按目录列出远程文件，逐个比对大小；每个目录只列出一次。
+func (u *Uploader) Verify(ctx context.Context, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	client, done, err := u.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	type listing struct {
+		entries map[string]fs.FileInfo
+		err     error
+	}
+	listings := make(map[string]listing)
+	results := make([]error, len(files))
+	for i, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := path.Dir(u.serverPath(storage.JoinRemote(remoteDir, lf.RelPath)))
+		l, ok := listings[dir]
+		if !ok {
+			infos, err := client.ReadDir(dir)
+			l.err = err
+			if errors.Is(err, fs.ErrNotExist) {
+				l.err = fmt.Errorf("远程缺少目录 '%s': %w", dir, storage.ErrNotFound)
+			}
+			l.entries = make(map[string]fs.FileInfo, len(infos))
+			for _, info := range infos {
+				l.entries[info.Name()] = info
+			}
+			listings[dir] = l
+		}
+		if l.err != nil {
+			results[i] = l.err
+			continue
+		}
+		info, ok := l.entries[path.Base(lf.RelPath)]
+		switch {
+		case !ok || info.IsDir():
+			results[i] = fmt.Errorf("远程缺少文件 '%s': %w", lf.RelPath, storage.ErrNotFound)
+		case info.Size() != lf.Size:
+			results[i] = fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 远程 %d): %w", lf.RelPath, lf.Size, info.Size(), storage.ErrMismatch)
+		}
+	}
+	logger.Log.Debugf("  -> SFTP 校验完成，共比对 %d 个文件。", len(files))
+	return results, nil
+}
+
+// dial 建立 SSH 连接并打开 SFTP 会话。ctx 被取消时连接会被立即关闭，
+// 以便中断正在进行的传输。返回的 done 函数用于释放连接。
+func (u *Uploader) dial(ctx context.Context) (*sftp.Client, func(), error) {
+	logger.Log.Debugf("  -> 正在连接 SFTP 服务器: %s", u.addr)
+
+	dialer := net.Dialer{Timeout: u.sshConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", u.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接 SFTP 服务器失败: %w", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, u.addr, u.sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("SSH 握手失败: %w", err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("打开 SFTP 会话失败: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { sshClient.Close() })
+	done := func() {
+		stop()
+		client.Close()
+		sshClient.Close()
+	}
+	return client, done, nil
+}
+
+// serverPath 将远程路径转换为服务器上的路径。
+func (u *Uploader) serverPath(remotePath string) string {
+	if u.root == "" {
+		return remotePath
+	}
+	return path.Join(u.root, remotePath)
+}
+
+// putFile 上传单个文件，必要时从远程已有的大小处续传。
+func putFile(client *sftp.Client, lf storage.LocalFile, dest string) error {
+	log := logger.Log
+
+	var offset int64
+	if info, err := client.Stat(dest); err == nil {
+		switch {
+		case info.Size() == lf.Size:
+			log.Debugf("  -> 远程文件已存在且大小一致，跳过: %s", dest)
+			return client.Chtimes(dest, lf.ModTime, lf.ModTime)
+		case info.Size() < lf.Size:
+			offset = info.Size()
+			log.Infof("  -> 从 %d/%d 字节处续传: %s", offset, lf.Size, dest)
+		}
+	}
+
+	src, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	dst, err := client.OpenFile(dest, flags)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	if _, err := dst.ReadFrom(src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	log.Debugf("  -> 已上传: %s", dest)
+	return client.Chtimes(dest, lf.ModTime, lf.ModTime)
+}
+
+func toFileInfo(remotePath string, info fs.FileInfo) *storage.FileInfo {
+	fi := &storage.FileInfo{
+		Path:    remotePath,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+	}
+	if fi.IsDir {
+		fi.Size = -1
+	}
+	return fi
+}
@@ -0,0 +1,440 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer 是进程内的 SSH/SFTP 服务，直接读写本机文件系统，Uploader 的 Root 指向 dir。
+// 接受用户 tester 的密码 "secret" 或 clientKey 对应的公钥。
+type testServer struct {
+	dir       string
+	addr      string
+	hostKey   ssh.PublicKey
+	clientKey ed25519.PrivateKey
+	conns     atomic.Int32 // 通过认证的 SSH 连接数
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "tester" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("密码错误")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "tester" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("公钥未授权")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &testServer{dir: t.TempDir(), addr: ln.Addr().String(), hostKey: signer.PublicKey(), clientKey: clientKey}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+// serve 处理一个 SSH 连接，只接受 sftp 子系统。
+func (s *testServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	s.conns.Add(1)
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			channel.Close()
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// path 返回远程路径在服务端磁盘上的位置。
+func (s *testServer) path(remotePath string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(remotePath))
+}
+
+// useServer 把 [SFTP] 配置指向测试服务，使用密码登录并跳过主机密钥校验。
+func useServer(s *testServer) {
+	config.Cfg = new(config.Config)
+	config.Cfg.SFTP.Host = s.addr
+	config.Cfg.SFTP.Username = "tester"
+	config.Cfg.SFTP.Password = "secret"
+	config.Cfg.SFTP.InsecureIgnoreHostKey = true
+	config.Cfg.SFTP.Root = filepath.ToSlash(s.dir)
+}
+
+func newTestUploader(t *testing.T, s *testServer) *Uploader {
+	t.Helper()
+	useServer(s)
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// verify 校验 localPath 中的所有文件，返回各文件不一致的原因（合并为一个错误）。
+func verify(u *Uploader, localPath, remoteDir string) error {
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	results, err := u.Verify(context.Background(), files, remoteDir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(results...)
+}
+
+func TestNewUploader(t *testing.T) {
+	config.Cfg = new(config.Config)
+	config.Cfg.SFTP.Host = "nas.lan"
+	config.Cfg.SFTP.Username = "tester"
+	config.Cfg.SFTP.Password = "secret"
+	config.Cfg.SFTP.InsecureIgnoreHostKey = true
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.addr != "nas.lan:22" {
+		t.Errorf("没有端口时地址为 %q，应使用 22 端口", u.addr)
+	}
+
+	config.Cfg.SFTP.Password = ""
+	if _, err := NewUploader(); err == nil {
+		t.Error("没有配置任何认证方式时应返回错误")
+	}
+	config.Cfg.SFTP.Password = "secret"
+	config.Cfg.SFTP.InsecureIgnoreHostKey = false
+	config.Cfg.SFTP.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	if _, err := NewUploader(); err == nil {
+		t.Error("known_hosts 文件不存在时应返回错误")
+	}
+}
+
+func TestUploadAndVerify(t *testing.T) {
+	s := newTestServer(t)
+	u := newTestUploader(t, s)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "ep1.mkv"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Upload(context.Background(), dir, "/backups/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := readFile(t, s.path("backups/tv/Show/Extras/info.nfo")); got != "<episodedetails/>" {
+		t.Errorf("info.nfo 的内容为 %q", got)
+	}
+	// 上传后保留原有的修改时间
+	if info, err := os.Stat(s.path("backups/tv/Show/ep1.mkv")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("远程 ep1.mkv 的修改时间为 %v, %v，应为 %v", info.ModTime(), err, modTime)
+	}
+	if err := verify(u, dir, "/backups/tv"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	writeFile(t, s.path("backups/tv/Show/ep1.mkv"), []byte("truncated"))
+	if err := verify(u, dir, "/backups/tv"); !errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("大小不同时 Verify 的错误为 %v，应为 ErrMismatch", err)
+	}
+	if err := verify(u, dir, "/movies"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("远程目录不存在时 Verify 的错误为 %v，应为 ErrNotFound", err)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string // 上传前远程已有的内容
+		want   string // 上传后远程的内容
+	}{
+		// 比本地小：从远程已有的大小处追加，已有的部分不会重传
+		{"续传", "EPISODE", "EPISODE one"},
+		// 大小一致：视为已上传完成，直接跳过
+		{"跳过", "EPISODE ONE", "EPISODE ONE"},
+		// 比本地大：说明不是同一个文件，重新上传
+		{"重传", "episode one, director's cut", "episode one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			u := newTestUploader(t, s)
+			dir := filepath.Join(t.TempDir(), "Show")
+			writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+			writeFile(t, s.path("tv/Show/ep1.mkv"), []byte(tt.remote))
+
+			if err := u.Upload(context.Background(), dir, "/tv"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if got := readFile(t, s.path("tv/Show/ep1.mkv")); got != tt.want {
+				t.Errorf("远程内容为 %q，应为 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+
+	useServer(s)
+	config.Cfg.SFTP.InsecureIgnoreHostKey = false
+	config.Cfg.SFTP.KnownHosts = knownHosts
+
+	writeFile(t, knownHosts, []byte(knownhosts.Line([]string{s.addr}, s.hostKey)+"\n"))
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Upload(context.Background(), dir, "/tv"); err != nil {
+		t.Fatalf("主机密钥一致时 Upload 失败: %v", err)
+	}
+
+	// 服务器的主机密钥与 known_hosts 中记录的不同，可能遭受了中间人攻击
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, knownHosts, []byte(knownhosts.Line([]string{s.addr}, otherKey)+"\n"))
+	if u, err = NewUploader(); err != nil {
+		t.Fatal(err)
+	}
+	var keyErr *knownhosts.KeyError
+	if _, err := u.Stat(context.Background(), "/tv/Show/ep1.mkv"); !errors.As(err, &keyErr) || errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("主机密钥不一致时 Stat 的错误为 %v，应为 knownhosts.KeyError", err)
+	}
+	if err := verify(u, dir, "/tv"); err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("主机密钥不一致时 Verify 的错误为 %v，应为无法校验", err)
+	}
+	if err := u.Upload(context.Background(), dir, "/movies"); err == nil {
+		t.Error("主机密钥不一致时 Upload 应失败")
+	}
+	if _, err := os.Stat(s.path("movies")); !os.IsNotExist(err) {
+		t.Errorf("主机密钥不一致时不应写入任何内容: %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+
+	tests := []struct {
+		name       string
+		password   string
+		passphrase string // 非空时私钥以该口令加密
+		ok         bool
+	}{
+		{"密码", "secret", "", true},
+		{"错误的密码", "wrong", "", false},
+		{"私钥", "", "", true},
+		{"加密的私钥", "", "hunter2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useServer(s)
+			config.Cfg.SFTP.Password = tt.password
+			if tt.password == "" {
+				var block *pem.Block
+				var err error
+				if tt.passphrase != "" {
+					block, err = ssh.MarshalPrivateKeyWithPassphrase(s.clientKey, "", []byte(tt.passphrase))
+				} else {
+					block, err = ssh.MarshalPrivateKey(s.clientKey, "")
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, keyFile, pem.EncodeToMemory(block))
+				config.Cfg.SFTP.PrivateKey = keyFile
+				config.Cfg.SFTP.PrivateKeyPassphrase = tt.passphrase
+			}
+			u, err := NewUploader()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 认证失败只说明无法访问，不代表远程文件缺失
+			_, err = u.Stat(context.Background(), "/")
+			if tt.ok && err != nil {
+				t.Errorf("Stat: %v", err)
+			}
+			if !tt.ok && (err == nil || errors.Is(err, storage.ErrNotFound)) {
+				t.Errorf("认证失败时 Stat 的错误为 %v，不应为 nil 或 ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	s := newTestServer(t)
+	u := newTestUploader(t, s)
+	ctx := context.Background()
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("episode"))
+	writeFile(t, s.path("tv/Show/Extras/info.nfo"), []byte("nfo"))
+
+	entries, err := u.List(ctx, "/tv/Show")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+		if (e.Name == "Extras") != e.IsDir || e.Path != "/tv/Show/"+e.Name {
+			t.Errorf("List 的结果 %+v 不正确", e)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"Extras", "ep1.mkv"}) {
+		t.Errorf("List = %v", names)
+	}
+	if _, err := u.List(ctx, "/tv/Missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("List(不存在) 的错误为 %v，应为 ErrNotFound", err)
+	}
+
+	// Delete 递归删除，删除不存在的路径不算错误
+	if err := u.Delete(ctx, "/tv/Show"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := u.Stat(ctx, "/tv/Show"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("删除后 Stat 的错误为 %v，应为 ErrNotFound", err)
+	}
+	if err := u.Delete(ctx, "/tv/Show"); err != nil {
+		t.Errorf("Delete(不存在): %v", err)
+	}
+}
+
+func TestVerifyPerFile(t *testing.T) {
+	s := newTestServer(t)
+	u := newTestUploader(t, s)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), []byte("episode two"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	files, err := storage.WalkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Upload(context.Background(), dir, "/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("truncated"))
+	if err := os.RemoveAll(s.path("tv/Show/Extras")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := s.conns.Load()
+	results, err := u.Verify(context.Background(), files, "/tv")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if n := s.conns.Load() - before; n != 1 {
+		t.Errorf("校验 %d 个文件建立了 %d 个 SSH 连接，应只建立 1 个", len(files), n)
+	}
+	want := map[string]error{"Show/Extras/info.nfo": storage.ErrNotFound, "Show/ep1.mkv": storage.ErrMismatch, "Show/ep2.mkv": nil}
+	for i, lf := range files {
+		if w := want[lf.RelPath]; (w == nil) != (results[i] == nil) || !errors.Is(results[i], w) {
+			t.Errorf("%s: 结果为 %v，应为 %v", lf.RelPath, results[i], w)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package storage
+
+import "errors"
+
+// FreeSpace 在当前平台上无法获取剩余空间。
+func FreeSpace(path string) (uint64, error) {
+	return 0, errors.New("当前平台不支持获取磁盘剩余空间")
+}
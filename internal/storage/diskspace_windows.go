@@ -0,0 +1,18 @@
+//go:build windows
+
+package storage
+
+import "golang.org/x/sys/windows"
+
+// FreeSpace 返回 path 所在磁盘中当前用户可用的剩余空间（字节）。
+func FreeSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
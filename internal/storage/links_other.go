@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package storage
+
+// linkCount 在当前平台上无法获取硬链接数量。
+func linkCount(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalFile 描述待上传内容中的一个本地文件。
+type LocalFile struct {
+	AbsPath string    // 本地绝对路径
+	RelPath string    // 相对于内容父目录的路径，使用 "/" 分隔，第一级即为内容本身的名称；远程保持同样的名称，不做字符替换
+	Size    int64     // 字节数
+	ModTime time.Time // 修改时间
+}
+
+// WalkLocal 递归列出 localPath（文件或目录）下的所有普通文件。
+// 返回的 RelPath 与 Upload 的远程布局一致: 拼接到 remoteDir 之后即为远程路径。
+func WalkLocal(localPath string) ([]LocalFile, error) {
+	root := filepath.Dir(localPath)
+	var files []LocalFile
+	err := filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, LocalFile{
+			AbsPath: p,
+			RelPath: filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("遍历本地路径 '%s' 失败: %w", localPath, err)
+	}
+	return files, nil
+}
+
+// HashFile 计算本地文件的哈希值（十六进制小写）。algo 支持 md5、sha1、sha256。
+func HashFile(filePath, algo string) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("不支持的哈希算法: %s", algo)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HardLinkedFiles 返回 localPath（文件或目录）下硬链接数量大于 1 的普通文件，
+// 这些文件的数据还被其他路径（例如媒体库）引用，删除本地副本并不会释放空间。
+// 当前平台无法获取硬链接数量时返回的 supported 为 false。
+func HardLinkedFiles(localPath string) (linked []string, supported bool, err error) {
+	files, err := WalkLocal(localPath)
+	if err != nil {
+		return nil, false, err
+	}
+	supported = true
+	for _, f := range files {
+		n, ok := linkCount(f.AbsPath)
+		if !ok {
+			supported = false
+			continue
+		}
+		if n > 1 {
+			linked = append(linked, f.AbsPath)
+		}
+	}
+	return linked, supported, nil
+}
+
+// FreeableSize 返回删除 localPath 后实际能释放的空间：只统计硬链接数量为 1 的文件，
+// 还被其他路径引用的文件删除后数据仍然保留。无法获取硬链接数量的文件按能释放计算。
+func FreeableSize(localPath string) (int64, error) {
+	files, err := WalkLocal(localPath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		if n, ok := linkCount(f.AbsPath); ok && n > 1 {
+			continue
+		}
+		size += f.Size
+	}
+	return size, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+)
+
+var (
+	// ErrNotFound 表示远程路径不存在。各后端在 Stat 等操作中找不到目标时应返回它（或包装它）。
+	ErrNotFound = errors.New("远程路径不存在")
+	// ErrMismatch 表示远程副本与本地内容不一致（大小或哈希不同）。
+	ErrMismatch = errors.New("远程副本与本地内容不一致")
+)
+
+// FileInfo 描述远程存储上的一个文件或目录。
+type FileInfo struct {
+	Path    string    // 远程完整路径
+	Name    string    // 文件名
+	Size    int64     // 字节数；-1 表示后端无法给出精确大小
+	IsDir   bool      // 是否为目录
+	ModTime time.Time // 修改时间；零值表示未知
+	MD5     string    // 远程记录的 md5（十六进制小写）；空表示后端不提供或未启用
+}
+
+// Backend 是调度器与存储后端之间的统一接口。
+// 所有路径均为远程路径，使用 "/" 分隔。
+type Backend interface {
+	// Name 返回后端名称，与 config.ini 中 [Uploader] Backend 的取值一致。
+	Name() string
+	// Upload 将本地文件或目录上传到远程目录 remoteDir 下，并保持其原有名称，
+	// 即上传完成后内容位于 remoteDir/<localPath 的最后一级名称>。
+	Upload(ctx context.Context, localPath, remoteDir string) error
+	// Exists 判断远程路径是否存在。
+	Exists(ctx context.Context, remotePath string) (bool, error)
+	// Stat 获取远程路径的信息，不存在时返回 ErrNotFound。
+	Stat(ctx context.Context, remotePath string) (*FileInfo, error)
+	// Delete 删除远程文件或目录。
+	Delete(ctx context.Context, remotePath string) error
+	// List 列出远程目录下的直接子项。
+	List(ctx context.Context, remoteDir string) ([]FileInfo, error)
+}
+
+// Verifier 是一个可选接口。实现它的后端可以一次列出远程目录后批量比对多个文件，
+// 比逐个文件调用 Stat 更快，也可以做更严格的（例如哈希）比对。
+//
+// Verify 校验 files 是否已完整地存在于 remoteDir 下（布局与 Upload 一致），返回与 files 一一对应的结果：
+// nil 表示一致，包装了 ErrNotFound 的错误表示远程缺失，包装了 ErrMismatch 的错误表示内容不一致，
+// 其余错误表示该文件无法校验。整个远程目录都无法列出时返回非 nil 的 err。
+type Verifier interface {
+	Verify(ctx context.Context, files []LocalFile, remoteDir string) ([]error, error)
+}
+
+// NameChecker 是一个可选接口，由对文件名有限制的后端实现。
+// 调度器在上传前用 CheckName 检查任务中的每一级文件和目录名，有名称无法使用时不会向该后端上传。
+type NameChecker interface {
+	CheckName(name string) error
+}
+
+// DirTimesRestorer 是一个可选接口，由会保留目录修改时间的后端实现。
+//
+// 调度器逐个文件调用 Upload 时，每次只能看到一个文件，写入文件又会改变其所在目录的修改时间；
+// 全部文件上传完成后再调用 RestoreDirTimes，将 remoteDir 下与 localPath 同名的目录树恢复为与本地一致。
+type DirTimesRestorer interface {
+	RestoreDirTimes(ctx context.Context, localPath, remoteDir string) error
+}
+
+// JoinRemote 拼接远程路径，统一使用 "/" 作为分隔符。
+func JoinRemote(elem ...string) string {
+	return path.Join(elem...)
+}
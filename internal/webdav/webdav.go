@@ -0,0 +1,355 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/logger"
+	"qbuploader/internal/storage"
+)
+
+// propfindBody 请求 PROPFIND 返回的属性。
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+// Uploader 通过 WebDAV 协议（PUT、MKCOL、PROPFIND）上传文件，实现了 storage.Backend 接口。
+// 适用于 Alist、Nextcloud 等提供 WebDAV 的服务。
+type Uploader struct {
+	baseURL  *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+var (
+	_ storage.Backend  = (*Uploader)(nil)
+	_ storage.Verifier = (*Uploader)(nil)
+)
+
+// NewUploader 根据 [WebDAV] 配置创建一个新的 WebDAV Uploader 实例。
+func NewUploader() (*Uploader, error) {
+	cfg := config.Cfg.WebDAV
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("[WebDAV] 中的 URL 不能为空")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("解析 WebDAV 地址失败: %w", err)
+	}
+	return &Uploader{
+		baseURL:  baseURL,
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{},
+	}, nil
+}
+
+// Name 返回后端名称。
+func (u *Uploader) Name() string {
+	return "webdav"
+}
+
+// Upload 逐个 PUT 文件到 remoteDir 下，缺失的目录会先通过 MKCOL 创建。
+// 远程已存在且大小一致的文件会被跳过，因此中断后可以直接重试。
+func (u *Uploader) Upload(ctx context.Context, localPath, remoteDir string) error {
+	log := logger.Log
+
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	log.Infof("  -> 正在上传: %s -> %s", localPath, u.resolve(remoteDir))
+
+	created := make(map[string]bool)
+	for _, lf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		remotePath := storage.JoinRemote(remoteDir, lf.RelPath)
+		if info, err := u.Stat(ctx, remotePath); err == nil && info.Size == lf.Size {
+			log.Debugf("  -> 远程文件已存在且大小一致，跳过: %s", remotePath)
+			continue
+		}
+		if err := u.mkdirAll(ctx, path.Dir(remotePath), created); err != nil {
+			return err
+		}
+		if err := u.put(ctx, lf, remotePath); err != nil {
+			return fmt.Errorf("上传 '%s' 失败: %w", lf.RelPath, err)
+		}
+		log.Debugf("  -> 已上传: %s", remotePath)
+	}
+	return nil
+}
+
+// Exists 判断远程路径是否存在。
+func (u *Uploader) Exists(ctx context.Context, remotePath string) (bool, error) {
+	logger.Log.Infof("  -> 正在校验 WebDAV 文件: %s", u.resolve(remotePath))
+	_, err := u.Stat(ctx, remotePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stat 通过 Depth 为 0 的 PROPFIND 获取远程路径信息。
+func (u *Uploader) Stat(ctx context.Context, remotePath string) (*storage.FileInfo, error) {
+	entries, err := u.propfind(ctx, remotePath, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	info := entries[0]
+	info.Path = remotePath
+	info.Name = path.Base(remotePath)
+	return &info, nil
+}
+
+// Delete 删除远程文件或目录。
+func (u *Uploader) Delete(ctx context.Context, remotePath string) error {
+	resp, err := u.do(ctx, http.MethodDelete, remotePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("DELETE '%s' 失败: %s", remotePath, resp.Status)
+	}
+}
+
+// List 通过 Depth 为 1 的 PROPFIND 列出远程目录下的直接子项。
+func (u *Uploader) List(ctx context.Context, remoteDir string) ([]storage.FileInfo, error) {
+	entries, err := u.propfind(ctx, remoteDir, "1")
+	if err != nil {
+		return nil, err
+	}
+	self := strings.TrimSuffix(u.resolve(remoteDir).Path, "/")
+	var children []storage.FileInfo
+	for _, entry := range entries {
+		if strings.TrimSuffix(entry.Path, "/") == self {
+			continue
+		}
+		entry.Name = path.Base(strings.TrimSuffix(entry.Path, "/"))
+		entry.Path = storage.JoinRemote(remoteDir, entry.Name)
+		children = append(children, entry)
+	}
+	return children, nil
+}
+
+// Verify 逐层 PROPFIND 远程目录，比对每个文件的大小；每个目录只列出一次。
+// 许多服务禁用了 Depth: infinity，因此这里按目录逐层列出。
+func (u *Uploader) Verify(ctx context.Context, files []storage.LocalFile, remoteDir string) ([]error, error) {
+	type listing struct {
+		entries map[string]storage.FileInfo
+		err     error
+	}
+	listings := make(map[string]listing)
+	results := make([]error, len(files))
+	for i, lf := range files {
+		remotePath := storage.JoinRemote(remoteDir, lf.RelPath)
+		dir := path.Dir(remotePath)
+		l, ok := listings[dir]
+		if !ok {
+			entries, err := u.List(ctx, dir)
+			l.err = err
+			if errors.Is(err, storage.ErrNotFound) {
+				l.err = fmt.Errorf("远程缺少目录 '%s': %w", dir, storage.ErrNotFound)
+			}
+			l.entries = make(map[string]storage.FileInfo, len(entries))
+			for _, e := range entries {
+				l.entries[e.Name] = e
+			}
+			listings[dir] = l
+		}
+		if l.err != nil {
+			results[i] = l.err
+			continue
+		}
+		entry, ok := l.entries[path.Base(remotePath)]
+		switch {
+		case !ok || entry.IsDir:
+			results[i] = fmt.Errorf("远程缺少文件 '%s': %w", lf.RelPath, storage.ErrNotFound)
+		case entry.Size != lf.Size:
+			results[i] = fmt.Errorf("文件 '%s' 大小不一致 (本地 %d, 远程 %d): %w", lf.RelPath, lf.Size, entry.Size, storage.ErrMismatch)
+		}
+	}
+	logger.Log.Debugf("  -> WebDAV 校验完成，共比对 %d 个文件。", len(files))
+	return results, nil
+}
+
+// put 上传单个文件。
+func (u *Uploader) put(ctx context.Context, lf storage.LocalFile, remotePath string) error {
+	f, err := os.Open(lf.AbsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	headers := map[string]string{
+		"Content-Type": "application/octet-stream",
+		// Nextcloud/ownCloud 会根据该头保留原始修改时间，其他服务会忽略它
+		"X-OC-Mtime": strconv.FormatInt(lf.ModTime.Unix(), 10),
+	}
+	resp, err := u.doWithLength(ctx, http.MethodPut, remotePath, f, lf.Size, headers)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PUT 失败: %s", resp.Status)
+	}
+	return nil
+}
+
+// mkdirAll 逐级创建远程目录，created 用于记录本次已确认存在的目录。
+func (u *Uploader) mkdirAll(ctx context.Context, remoteDir string, created map[string]bool) error {
+	if remoteDir == "/" || remoteDir == "." || created[remoteDir] {
+		return nil
+	}
+	if err := u.mkdirAll(ctx, path.Dir(remoteDir), created); err != nil {
+		return err
+	}
+	resp, err := u.do(ctx, "MKCOL", remoteDir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 201 表示创建成功，405 表示目录已存在
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL '%s' 失败: %s", remoteDir, resp.Status)
+	}
+	created[remoteDir] = true
+	return nil
+}
+
+// multistatus 对应 PROPFIND 返回的 207 Multi-Status 响应。
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind 执行 PROPFIND 请求，返回的 FileInfo.Path 为服务端返回的 URL 路径（已解码）。
+func (u *Uploader) propfind(ctx context.Context, remotePath, depth string) ([]storage.FileInfo, error) {
+	headers := map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}
+	resp, err := u.do(ctx, "PROPFIND", remotePath, strings.NewReader(propfindBody), headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", remotePath, storage.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND '%s' 失败: %s", remotePath, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("解析 PROPFIND 响应失败: %w", err)
+	}
+
+	var entries []storage.FileInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		entry := storage.FileInfo{Path: href.Path, Size: -1}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			entry.IsDir = ps.Prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+				entry.Size = size
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				entry.ModTime = t
+			}
+		}
+		if entry.IsDir {
+			entry.Size = -1
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// resolve 将远程路径转换为完整的 URL。每一段都先转义，文件名中的 "#"、"%" 等字符不会被当作 URL 的一部分。
+func (u *Uploader) resolve(remotePath string) *url.URL {
+	segments := strings.Split(strings.Trim(remotePath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	if strings.HasSuffix(remotePath, "/") {
+		segments[len(segments)-1] += "/"
+	}
+	return u.baseURL.JoinPath(segments...)
+}
+
+func (u *Uploader) do(ctx context.Context, method, remotePath string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return u.doWithLength(ctx, method, remotePath, body, -1, headers)
+}
+
+func (u *Uploader) doWithLength(ctx context.Context, method, remotePath string, body io.Reader, length int64, headers map[string]string) (*http.Response, error) {
+	target := u.resolve(remotePath)
+	logger.Log.Debugf("  -> WebDAV 请求: %s %s", method, target.Redacted())
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if length >= 0 {
+		req.ContentLength = length
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if u.username != "" {
+		req.SetBasicAuth(u.username, u.password)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WebDAV %s 请求失败: %w", method, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,419 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qbuploader/internal/config"
+	"qbuploader/internal/storage"
+
+	"golang.org/x/net/webdav"
+)
+
+// testServer 是基于 golang.org/x/net/webdav 的测试服务，文件保存在 dir 中。
+// canned 中登记的路径不经过 webdav.Handler，而是直接返回预先设置的响应，用于模拟各种服务端。
+type testServer struct {
+	dir string
+
+	mu       sync.Mutex
+	requests []string                // "方法 路径"
+	mtimes   map[string]string       // PUT 请求的 X-OC-Mtime
+	canned   map[string]http.Handler // 路径 -> 响应
+}
+
+func newTestServer(t *testing.T) (*Uploader, *testServer) {
+	t.Helper()
+	s := &testServer{dir: t.TempDir(), mtimes: make(map[string]string), canned: make(map[string]http.Handler)}
+	handler := &webdav.Handler{Prefix: "/dav", FileSystem: webdav.Dir(s.dir), LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "tester" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPut {
+			s.mtimes[r.URL.Path] = r.Header.Get("X-OC-Mtime")
+		}
+		canned := s.canned[r.URL.Path]
+		s.mu.Unlock()
+		if canned != nil {
+			canned.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	config.Cfg = new(config.Config)
+	config.Cfg.WebDAV.URL = srv.URL + "/dav/"
+	config.Cfg.WebDAV.Username = "tester"
+	config.Cfg.WebDAV.Password = "secret"
+	u, err := NewUploader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, s
+}
+
+// respond 让 urlPath 返回固定的状态码和响应体。
+func (s *testServer) respond(urlPath string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.canned[urlPath] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	})
+}
+
+// takeRequests 返回并清空目前记录的请求。
+func (s *testServer) takeRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func (s *testServer) mtime(urlPath string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mtimes[urlPath]
+}
+
+// path 返回远程路径在服务端磁盘上的位置。
+func (s *testServer) path(remotePath string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(remotePath))
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify 校验 localPath 中的所有文件，返回各文件不一致的原因（合并为一个错误）。
+func verify(u *Uploader, localPath, remoteDir string) error {
+	files, err := storage.WalkLocal(localPath)
+	if err != nil {
+		return err
+	}
+	results, err := u.Verify(context.Background(), files, remoteDir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(results...)
+}
+
+func TestUpload(t *testing.T) {
+	u, s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "ep1.mkv"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Upload(context.Background(), dir, "backups/tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	for _, name := range []string{"ep1.mkv", "Extras/info.nfo"} {
+		want, _ := os.ReadFile(filepath.Join(dir, name))
+		got, err := os.ReadFile(s.path("backups/tv/Show/" + name))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("远程 %s 的内容为 %q, %v", name, got, err)
+		}
+	}
+	// 每一级目录只 MKCOL 一次
+	var mkcols []string
+	for _, r := range s.takeRequests() {
+		if strings.HasPrefix(r, "MKCOL ") {
+			mkcols = append(mkcols, strings.TrimPrefix(r, "MKCOL "))
+		}
+	}
+	want := []string{"/dav/backups/", "/dav/backups/tv/", "/dav/backups/tv/Show/", "/dav/backups/tv/Show/Extras/"}
+	if !slices.Equal(mkcols, want) {
+		t.Errorf("MKCOL 请求为 %v，应为 %v", mkcols, want)
+	}
+	if got := s.mtime("/dav/backups/tv/Show/ep1.mkv"); got != strconv.FormatInt(modTime.Unix(), 10) {
+		t.Errorf("X-OC-Mtime 为 %q，应为 %d", got, modTime.Unix())
+	}
+	if err := verify(u, dir, "backups/tv"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestUploadSkipsSameSize(t *testing.T) {
+	u, s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), []byte("episode two"))
+
+	// 上次上传时 ep1.mkv 已经完成，ep2.mkv 只传了一半
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("episode one"))
+	writeFile(t, s.path("tv/Show/ep2.mkv"), []byte("episode"))
+	s.takeRequests()
+
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	var puts []string
+	for _, r := range s.takeRequests() {
+		if strings.HasPrefix(r, "PUT ") {
+			puts = append(puts, strings.TrimPrefix(r, "PUT "))
+		}
+	}
+	if !slices.Equal(puts, []string{"/dav/tv/Show/ep2.mkv"}) {
+		t.Errorf("PUT 请求为 %v，应只重新上传 ep2.mkv", puts)
+	}
+	if err := verify(u, dir, "tv"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	u, s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("truncated"))
+	if err := verify(u, dir, "tv"); !errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("大小不同时 Verify 的错误为 %v，应为 ErrMismatch", err)
+	}
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("episode one"))
+	if err := os.Remove(s.path("tv/Show/Extras/info.nfo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(u, dir, "tv"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("缺少文件时 Verify 的错误为 %v，应为 ErrNotFound", err)
+	}
+	// 远程同名的是目录而不是文件
+	if err := os.Mkdir(s.path("tv/Show/Extras/info.nfo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(u, dir, "tv"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("远程为目录时 Verify 的错误为 %v，应为 ErrNotFound", err)
+	}
+	if err := verify(u, dir, "movies"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("远程目录不存在时 Verify 的错误为 %v，应为 ErrNotFound", err)
+	}
+}
+
+// multistatusFile 是只包含一个文件的 207 响应，href 使用完整 URL 并经过转义（Alist 的格式）。
+const multistatusFile = `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>http://example.com/dav/raw/%E7%AC%AC%201%20%E9%9B%86.mkv</D:href>
+    <D:propstat>
+      <D:prop>
+        <D:resourcetype></D:resourcetype>
+        <D:getcontentlength>7</D:getcontentlength>
+        <D:getlastmodified>Wed, 01 May 2024 12:00:00 GMT</D:getlastmodified>
+      </D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`
+
+// multistatusNoLength 中 getcontentlength 出现在 404 的 propstat 里，即服务端不提供文件大小。
+const multistatusNoLength = `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/dav/raw/ep1.mkv</D:href>
+    <D:propstat>
+      <D:prop><D:resourcetype/></D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+    <D:propstat>
+      <D:prop><D:getcontentlength>7</D:getcontentlength></D:prop>
+      <D:status>HTTP/1.1 404 Not Found</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`
+
+func TestPropfindStatus(t *testing.T) {
+	u, s := newTestServer(t)
+	ctx := context.Background()
+
+	s.respond("/dav/raw/第 1 集.mkv", http.StatusMultiStatus, multistatusFile)
+	info, err := u.Stat(ctx, "raw/第 1 集.mkv")
+	if err != nil || info.Size != 7 || info.IsDir || info.Name != "第 1 集.mkv" {
+		t.Errorf("207: Stat = %+v, %v", info, err)
+	}
+	if !info.ModTime.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("207: ModTime = %v", info.ModTime)
+	}
+
+	s.respond("/dav/raw/ep1.mkv", http.StatusMultiStatus, multistatusNoLength)
+	if info, err := u.Stat(ctx, "raw/ep1.mkv"); err != nil || info.Size != -1 {
+		t.Errorf("207 且没有大小: Stat = %+v, %v，Size 应为 -1", info, err)
+	}
+
+	// 404 表示不存在，其他状态说明服务端或配置有问题，不能当作不存在
+	tests := []struct {
+		status   int
+		body     string
+		notFound bool
+	}{
+		{http.StatusNotFound, "", true},
+		{http.StatusOK, "<html>login</html>", false},
+		{http.StatusUnauthorized, "", false},
+		{http.StatusForbidden, "", false},
+		{http.StatusInternalServerError, "", false},
+		{http.StatusMultiStatus, "not xml", false},
+	}
+	for _, tt := range tests {
+		s.respond("/dav/raw/status.mkv", tt.status, tt.body)
+		_, err := u.Stat(ctx, "raw/status.mkv")
+		if err == nil || errors.Is(err, storage.ErrNotFound) != tt.notFound {
+			t.Errorf("%d: Stat 的错误为 %v，ErrNotFound 应为 %v", tt.status, err, tt.notFound)
+		}
+		exists, err := u.Exists(ctx, "raw/status.mkv")
+		if tt.notFound && (exists || err != nil) {
+			t.Errorf("%d: Exists = %v, %v，应为 false, nil", tt.status, exists, err)
+		}
+		if !tt.notFound && err == nil {
+			t.Errorf("%d: Exists 应返回错误", tt.status)
+		}
+	}
+
+	// 目录的 PROPFIND 出错时，Verify 应报告无法校验，而不是文件缺失
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode"))
+	s.respond("/dav/broken/Show", http.StatusInternalServerError, "")
+	if err := verify(u, dir, "broken"); err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrMismatch) {
+		t.Errorf("服务端出错时 Verify 的错误为 %v，应为无法校验", err)
+	}
+}
+
+func TestUploadSpecialNames(t *testing.T) {
+	u, s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "剧集 S01")
+	writeFile(t, filepath.Join(dir, "第 1 集 #1 (50%).mkv"), []byte("episode"))
+
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := os.Stat(s.path("tv/剧集 S01/第 1 集 #1 (50%).mkv")); err != nil {
+		t.Errorf("远程文件名不正确: %v", err)
+	}
+	// PROPFIND 返回的 href 是转义过的，需要解码后才能与本地文件名比对
+	if err := verify(u, dir, "tv"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	u, s := newTestServer(t)
+	ctx := context.Background()
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("episode"))
+	writeFile(t, s.path("tv/Show/Extras/info.nfo"), []byte("nfo"))
+
+	entries, err := u.List(ctx, "tv/Show")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+		if (e.Name == "Extras") != e.IsDir || e.Path != "tv/Show/"+e.Name {
+			t.Errorf("List 的结果 %+v 不正确", e)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"Extras", "ep1.mkv"}) {
+		t.Errorf("List = %v，不应包含目录自身", names)
+	}
+
+	if err := u.Delete(ctx, "tv/Show"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(s.path("tv/Show")); !os.IsNotExist(err) {
+		t.Errorf("删除后目录仍然存在: %v", err)
+	}
+	// 删除不存在的路径不算错误
+	if err := u.Delete(ctx, "tv/Show"); err != nil {
+		t.Errorf("Delete(不存在): %v", err)
+	}
+}
+
+func TestVerifyPerFile(t *testing.T) {
+	u, s := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "Show")
+	writeFile(t, filepath.Join(dir, "ep1.mkv"), []byte("episode one"))
+	writeFile(t, filepath.Join(dir, "ep2.mkv"), []byte("episode two"))
+	writeFile(t, filepath.Join(dir, "Extras", "info.nfo"), []byte("<episodedetails/>"))
+	writeFile(t, filepath.Join(dir, "Subs", "ep1.srt"), []byte("subtitle"))
+	files, err := storage.WalkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Upload(context.Background(), dir, "tv"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	writeFile(t, s.path("tv/Show/ep1.mkv"), []byte("truncated"))
+	if err := os.RemoveAll(s.path("tv/Show/Extras")); err != nil {
+		t.Fatal(err)
+	}
+	s.respond("/dav/tv/Show/Subs", http.StatusInternalServerError, "")
+	s.takeRequests()
+
+	results, err := u.Verify(context.Background(), files, "tv")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// 每个目录只 PROPFIND 一次
+	var propfinds []string
+	for _, r := range s.takeRequests() {
+		if strings.HasPrefix(r, "PROPFIND ") {
+			propfinds = append(propfinds, strings.TrimPrefix(r, "PROPFIND "))
+		}
+	}
+	slices.Sort(propfinds)
+	if want := []string{"/dav/tv/Show", "/dav/tv/Show/Extras", "/dav/tv/Show/Subs"}; !slices.Equal(propfinds, want) {
+		t.Errorf("PROPFIND 请求为 %v，应为 %v", propfinds, want)
+	}
+	// 一个目录出错只影响该目录下的文件：缺失的目录算缺失，无法列出的目录算无法校验
+	for i, lf := range files {
+		got := results[i]
+		switch lf.RelPath {
+		case "Show/ep2.mkv":
+			if got != nil {
+				t.Errorf("%s: 应校验通过，实际为 %v", lf.RelPath, got)
+			}
+		case "Show/ep1.mkv":
+			if !errors.Is(got, storage.ErrMismatch) {
+				t.Errorf("%s: 应为 ErrMismatch，实际为 %v", lf.RelPath, got)
+			}
+		case "Show/Extras/info.nfo":
+			if !errors.Is(got, storage.ErrNotFound) {
+				t.Errorf("%s: 应为 ErrNotFound，实际为 %v", lf.RelPath, got)
+			}
+		case "Show/Subs/ep1.srt":
+			if got == nil || errors.Is(got, storage.ErrNotFound) || errors.Is(got, storage.ErrMismatch) {
+				t.Errorf("%s: 应为无法校验，实际为 %v", lf.RelPath, got)
+			}
+		}
+	}
+}